@@ -15,6 +15,26 @@ const (
 	ServerModeTcp       = "tcp"
 	ServerModeWebSocket = "websocket"
 	ServerModeKcp       = "kcp"
+	ServerModeUnix      = "unix"
+)
+
+// The block ciphers of KCP packets (KCP报文加密算法)
+const (
+	KcpCryptNone    = "none"
+	KcpCryptAES     = "aes"
+	KcpCryptAES128  = "aes-128"
+	KcpCryptAES192  = "aes-192"
+	KcpCryptSalsa20 = "salsa20"
+	KcpCryptSM4     = "sm4"
+)
+
+// The verify modes of client certificates (客户端证书校验模式)
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequire          = "require"
+	ClientAuthVerifyIfGiven    = "verify_if_given"
+	ClientAuthRequireAndVerify = "require_and_verify"
 )
 
 const (
@@ -22,6 +42,34 @@ const (
 	WorkerModeBind = "Bind"
 )
 
+// The policies when an index key of the ConnManager is bound to a second connection (ConnManager索引键被绑定到第二个连接时的处理策略)
+const (
+	ConnIndexKickOld   = "kick_old"
+	ConnIndexRejectNew = "reject_new"
+	ConnIndexAllow     = "allow"
+)
+
+// ListenerConfig describes one listener of the server
+// (服务器的一个监听配置)
+type ListenerConfig struct {
+	Mode string // The transport of the listener, "tcp", "websocket", "kcp" or "unix".(监听的传输协议)
+	Host string // The IP address to listen on, Config.Host is used if it is empty.(监听的IP，为空时使用Config.Host)
+	Port int    // The port number to listen on.(监听的端口号)
+
+	// The socket path of a "unix" listener, a path starting with "@" is an abstract socket on Linux.
+	// For a "websocket" listener it is the upgrade path, Config.WsPath is used if it is empty.
+	// (unix监听的socket路径，Linux下以"@"开头表示抽象命名空间socket；websocket监听的升级路径，为空时使用Config.WsPath)
+	Path string
+
+	// Whether every connection of a "tcp" or "websocket" listener starts with a PROXY protocol v1/v2 header.
+	// (tcp或websocket监听的每个连接是否以PROXY protocol v1/v2头部开始)
+	ProxyProtocol bool
+
+	// The number of SO_REUSEPORT sockets opened for a "tcp" listener on Linux, each one with its own accept loop.
+	// 0 or 1 means a single socket.(Linux下tcp监听打开的SO_REUSEPORT socket数量，每个socket有独立的Accept循环，0或1表示单个socket)
+	ReusePort int
+}
+
 type Config struct {
 	/*
 		Server
@@ -29,9 +77,14 @@ type Config struct {
 	Host    string // The IP address of the current server. (当前服务器主机IP)
 	TcpPort int    // The port number on which the server listens for TCP connections.(当前服务器主机监听端口号)
 	WsPort  int    // The port number on which the server listens for WebSocket connections.(当前服务器主机websocket监听端口)
+	WsPath  string // The HTTP path on which WebSocket connections are upgraded, default "/".(websocket升级路径，默认"/")
 	Name    string // The name of the current server.(当前服务器名称)
 	KcpPort int    // he port number on which the server listens for KCP connections.(当前服务器主机监听端口号)
 
+	// The socket path on which the server listens for unix connections, "@" prefix means an abstract socket on Linux.
+	// (当前服务器unix监听的socket路径，Linux下以"@"开头表示抽象命名空间socket)
+	UnixPath string
+
 	/*
 		ServerConfig
 	*/
@@ -46,6 +99,23 @@ type Config struct {
 	KcpFecDataShards   int  // The number of data shards in the FEC.(FEC数据分片), default 0.
 	KcpFecParityShards int  // The number of parity shards in the FEC.(FEC校验分片) default 0.
 
+	// The block cipher of KCP packets: "aes", "aes-128", "aes-192", "salsa20", "sm4" or "none" (default).
+	// (KCP报文加密算法，默认"none"不加密)
+	KcpCrypt string
+	// The shared secret from which the KCP cipher key is derived with PBKDF2.(派生KCP加密密钥的共享密钥)
+	KcpKey string
+	// The salt of the PBKDF2 key derivation, a built-in salt is used if it is empty.(PBKDF2密钥派生的盐，为空时使用内置值)
+	KcpSalt string
+
+	// The socket options of tcp connections, accepted by the tcp and websocket listeners or dialed by clients,
+	// 0 keeps the default of Go and the operating system.(tcp连接的socket选项，作用于tcp和websocket监听接收的连接及客户端拨号的连接，0表示使用Go和操作系统的默认值)
+	TcpNoDelay     int // TCP_NODELAY, 1 sends small packets at once (Go default), -1 enables Nagle's algorithm.(1立即发送小包(Go默认)，-1启用Nagle算法)
+	TcpKeepAlive   int // The keepalive period in seconds, -1 disables keepalive.(TCP keepalive探测间隔(单位：秒)，-1关闭keepalive)
+	TcpReadBuffer  int // SO_RCVBUF in bytes.(接收缓冲区大小，单位：字节)
+	TcpWriteBuffer int // SO_SNDBUF in bytes.(发送缓冲区大小，单位：字节)
+	TcpLinger      int // SO_LINGER in seconds, -1 resets the connection on close discarding unsent data.(关闭时等待未发送数据的时间(单位：秒)，-1关闭时直接重置连接并丢弃未发送数据)
+	TcpUserTimeout int // TCP_USER_TIMEOUT in milliseconds, Linux only.(未确认数据的最长等待时间(单位：毫秒)，仅Linux)
+
 	/*
 		Zinx
 	*/
@@ -57,17 +127,51 @@ type Config struct {
 	WorkerMode       string // The way to assign workers to connections.(为链接分配worker的方式)
 	MaxMsgChanLen    uint32 // The maximum length of the send buffer message queue.(SendBuffMsg发送消息的缓冲最大长度)
 	IOReadBuffSize   uint32 // The maximum size of the read buffer for each IO operation.(每次IO最大的读取长度)
+	HandlerTimeout   int    // The deadline in milliseconds of the request context in the handlers, 0 means none.(处理函数中请求context的截止时间(单位：毫秒)，0表示不限制)
+
+	// What happens when an index key of the ConnManager (e.g. a user ID) is bound to a second connection:
+	// "kick_old" (default) stops the old connection, "reject_new" fails the new binding, "allow" keeps both.
+	// (ConnManager的索引键(如用户ID)被绑定到第二个连接时的处理策略："kick_old"(默认)停止旧连接，"reject_new"拒绝新的绑定，"allow"同时保留)
+	ConnIndexPolicy string
+
+	// Admission control of a single remote IP, 0 means unlimited.(单个远端IP的准入控制，0表示不限制)
+	MaxConnPerIP     int     // The maximum number of concurrent connections from one IP.(单个IP允许的最大并发链接数)
+	AcceptRatePerIP  float64 // The number of new connections per second accepted from one IP.(单个IP每秒允许建立的新链接数)
+	AcceptBurstPerIP int     // The burst of new connections from one IP, default max(1, AcceptRatePerIP).(单个IP允许突发建立的链接数)
 
 	//The server mode, which can be "tcp" or "websocket". If it is empty, both modes are enabled.
 	//"tcp":tcp监听, "websocket":websocket 监听 为空时同时开启
 	Mode string
 
+	// The listeners started by the server. Any combination of transports and ports can be used,
+	// all of them share the same MsgHandler and ConnManager. If it is empty, the listeners are derived from Mode.
+	// (服务器启动的监听列表，可以任意组合传输协议和端口，共用同一个MsgHandler和ConnManager，为空时根据Mode生成)
+	Listeners []ListenerConfig
+
+	// Whether the tcp and websocket listeners derived from Mode expect a PROXY protocol v1/v2 header,
+	// enable it only behind a load balancer that always sends one.
+	// (根据Mode生成的tcp和websocket监听是否要求PROXY protocol v1/v2头部，仅在总会发送该头部的负载均衡之后开启)
+	ProxyProtocol bool
+
+	// The timeout in seconds to read the PROXY protocol header, default 5.(读取PROXY protocol头部的超时时间(单位：秒)，默认5)
+	ProxyHeaderTimeout int
+
+	// The number of SO_REUSEPORT sockets of the tcp listener derived from Mode, see ListenerConfig.ReusePort.
+	// (根据Mode生成的tcp监听打开的SO_REUSEPORT socket数量，见ListenerConfig.ReusePort)
+	ReusePort int
+
 	// A boolean value that indicates whether the new or old version of the router is used. The default value is false.
 	// 路由模式 false为旧版本路由，true为启用新版本的路由 默认使用旧版本
 	RouterSlicesMode bool
 
 	// 是否开启 Request 对象池模式
 	RequestPoolMode bool
+
+	// Enables request/response calls (IConnection.Call and IRequest.Reply), both peers must enable it. The two highest
+	// bits of the msgID then mark calls and replies, so the routed msgIDs must not exceed gpack.MsgIDMask.
+	// (开启请求/响应调用(IConnection.Call和IRequest.Reply)，通信双方都需开启。开启后msgID的最高两位用于标记调用和应答，
+	// 因此路由的msgID不能超过gpack.MsgIDMask)
+	CallMode bool
 	/*
 		logger
 	*/
@@ -97,6 +201,53 @@ type Config struct {
 	*/
 	CertFile       string // The name of the certificate file. If it is empty, TLS encryption is not enabled.(证书文件名称 默认"")
 	PrivateKeyFile string // The name of the private key file. If it is empty, TLS encryption is not enabled.(私钥文件名称 默认"" --如果没有设置证书和私钥文件，则不启用TLS加密)
+	WsTLS          bool   // Whether the websocket listeners serve wss with the certificate above.(websocket监听是否使用上述证书提供wss服务)
+
+	// The interval in seconds to poll the certificate files and reload them when modified, 0 disables polling.
+	// (轮询证书文件的间隔(单位：秒)，文件修改后重新加载，为0时不轮询)
+	CertReloadInterval int
+
+	// Whether to reload the certificate files on SIGHUP.(收到SIGHUP信号时是否重新加载证书文件)
+	CertReloadOnSIGHUP bool
+
+	// The PEM CA bundle used to verify client certificates (mutual TLS).(校验客户端证书的CA证书包，用于双向TLS)
+	ClientCAFile string
+
+	// The verify mode of client certificates: "none", "request", "require", "verify_if_given" or "require_and_verify".
+	// If it is empty, "require_and_verify" is used when ClientCAFile is set, otherwise "none".
+	// (客户端证书校验模式，为空时如果设置了ClientCAFile则为"require_and_verify"，否则为"none")
+	ClientAuth string
+
+	/*
+		Admin
+	*/
+	// The address of the admin HTTP listener serving JSON about the running server, e.g. "127.0.0.1:9090".
+	// If it is empty, the admin listener is not started.(管理HTTP监听地址，提供运行中服务的JSON信息，为空时不启动)
+	AdminAddr string
+	// The bearer token required by the admin endpoints. If it is empty the read-only endpoints need no authentication
+	// and the kick endpoint is not served.(管理接口要求的Bearer令牌，为空时只读接口不鉴权，且不提供踢下线接口)
+	AdminToken string
+
+	/*
+		Metrics
+	*/
+	// Whether connections, messages and handler latencies are counted, implied by MetricsAddr.(是否统计连接、消息和处理耗时，设置MetricsAddr时自动开启)
+	Metrics bool
+	// The address of the HTTP listener serving the metrics in the Prometheus text format, e.g. "127.0.0.1:9100".
+	// If it is empty, the metrics listener is not started.(以Prometheus文本格式提供指标的HTTP监听地址，为空时不启动)
+	MetricsAddr string
+	// The HTTP path of the metrics, default "/metrics".(指标的HTTP路径，默认"/metrics")
+	MetricsPath string
+
+	/*
+		Session
+	*/
+	// How long in seconds the session of a closed connection is kept for the client to resume it, 0 disables sessions.
+	// (连接关闭后保留其会话供客户端恢复的时长(单位：秒)，0表示不开启会话)
+	SessionGracePeriod int
+	// The maximum number of messages sent to a detached session that are replayed on resume, default 64, -1 disables it.
+	// (会话断开期间发送、并在恢复时重放的最大消息数量，默认64，-1表示不缓存)
+	SessionReplayLen int
 }
 
 var GlobalObject *Config
@@ -148,10 +299,65 @@ func (c *Config) Show() {
 	fmt.Println("==============================")
 }
 
+// GetListeners returns the configured listeners, or the listeners derived from Mode if none is configured
+// (获取监听列表，未配置时根据Mode生成)
+func (c *Config) GetListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		listeners := make([]ListenerConfig, 0, len(c.Listeners))
+		for _, l := range c.Listeners {
+			if l.Host == "" && l.Mode != ServerModeUnix {
+				l.Host = c.Host
+			}
+			listeners = append(listeners, l)
+		}
+		return listeners
+	}
+
+	switch c.Mode {
+	case ServerModeTcp:
+		return []ListenerConfig{{Mode: ServerModeTcp, Host: c.Host, Port: c.TcpPort, ProxyProtocol: c.ProxyProtocol, ReusePort: c.ReusePort}}
+	case ServerModeWebSocket:
+		return []ListenerConfig{{Mode: ServerModeWebSocket, Host: c.Host, Port: c.WsPort, ProxyProtocol: c.ProxyProtocol}}
+	case ServerModeKcp:
+		return []ListenerConfig{{Mode: ServerModeKcp, Host: c.Host, Port: c.KcpPort}}
+	case ServerModeUnix:
+		return []ListenerConfig{{Mode: ServerModeUnix, Path: c.UnixPath}}
+	default:
+		return []ListenerConfig{
+			{Mode: ServerModeTcp, Host: c.Host, Port: c.TcpPort, ProxyProtocol: c.ProxyProtocol, ReusePort: c.ReusePort},
+			{Mode: ServerModeWebSocket, Host: c.Host, Port: c.WsPort, ProxyProtocol: c.ProxyProtocol},
+		}
+	}
+}
+
+// Clone returns a copy of the config, which can be changed without affecting c
+// (返回配置的副本，修改副本不会影响c)
+func (c *Config) Clone() *Config {
+	config := *c
+	config.Listeners = append([]ListenerConfig(nil), c.Listeners...)
+	return &config
+}
+
+func (c *Config) CertReloadIntervalDuration() time.Duration {
+	return time.Duration(c.CertReloadInterval) * time.Second
+}
+
+func (c *Config) ProxyHeaderTimeoutDuration() time.Duration {
+	return time.Duration(c.ProxyHeaderTimeout) * time.Second
+}
+
 func (c *Config) HeartbeatMaxDuration() time.Duration {
 	return time.Duration(c.HeartbeatMax) * time.Second
 }
 
+func (c *Config) SessionGracePeriodDuration() time.Duration {
+	return time.Duration(c.SessionGracePeriod) * time.Second
+}
+
+func (c *Config) HandlerTimeoutDuration() time.Duration {
+	return time.Duration(c.HandlerTimeout) * time.Millisecond
+}
+
 func (c *Config) InitLogConfig() {
 	if c.LogFile != "" {
 		glog.SetLogFile(c.LogDir, c.LogFile)
@@ -184,6 +390,7 @@ func init() {
 		Version:           "V1.0",
 		TcpPort:           8999,
 		WsPort:            9000,
+		WsPath:            "/",
 		KcpPort:           9001,
 		Host:              "0.0.0.0",
 		MaxConn:           12000,
@@ -202,6 +409,7 @@ func init() {
 		Mode:              ServerModeTcp,
 		RouterSlicesMode:  false,
 		RequestPoolMode:   false,
+		CallMode:          false,
 		KcpACKNoDelay:     false,
 		KcpStreamMode:     true,
 		//Normal Mode: ikcp_nodelay(kcp, 0, 40, 0, 0);
@@ -214,6 +422,10 @@ func init() {
 		KcpSendWindow:      32,
 		KcpFecDataShards:   0,
 		KcpFecParityShards: 0,
+		KcpCrypt:           KcpCryptNone,
+		ProxyHeaderTimeout: 5,
+		MetricsPath:        "/metrics",
+		SessionReplayLen:   64,
 	}
 
 	// Note: Load some user-configured parameters from the configuration file.
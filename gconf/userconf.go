@@ -2,136 +2,273 @@ package gconf
 
 import "github.com/liyee/gray/glog"
 
+// UserConfToGlobal copies the non-zero fields of config to the GlobalObject
 // (注意如果使用UserConf应该调用方法同步至 GlobalConfObject 因为其他参数是调用的此结构体参数)
 func UserConfToGlobal(config *Config) {
+	GlobalObject.merge(config)
+	GlobalObject.applyLog(config)
+}
+
+// NewUserConf returns a copy of the GlobalObject overridden by the non-zero fields of config.
+// Unlike UserConfToGlobal the GlobalObject is left untouched, so servers and clients with
+// different configs can live in one process. The log settings are process wide and still applied.
+// (返回GlobalObject的副本，并用config中的非零字段覆盖。与UserConfToGlobal不同，不会修改GlobalObject，
+// 因此同一进程中可以运行不同配置的服务和客户端。日志配置是进程级的，仍然会生效)
+func NewUserConf(config *Config) *Config {
+	c := GlobalObject.Clone()
+	c.merge(config)
+	c.applyLog(config)
+	return c
+}
+
+// applyLog applies the log settings of the user config to the logger
+// (将用户配置中的日志设置应用到日志模块)
+func (c *Config) applyLog(config *Config) {
+	if c.LogIsolationLevel > glog.LogDebug {
+		glog.SetLogLevel(c.LogIsolationLevel)
+	}
+	if config.LogFile != "" {
+		glog.SetLogFile(c.LogDir, c.LogFile)
+	}
+}
+
+// merge copies the non-zero fields of config to c (将config中的非零字段复制到c)
+func (c *Config) merge(config *Config) {
 
 	// Server
 	if config.Name != "" {
-		GlobalObject.Name = config.Name
+		c.Name = config.Name
 	}
 	if config.Host != "" {
-		GlobalObject.Host = config.Host
+		c.Host = config.Host
 	}
 	if config.TcpPort != 0 {
-		GlobalObject.TcpPort = config.TcpPort
+		c.TcpPort = config.TcpPort
+	}
+
+	// TCP socket options
+	if config.TcpNoDelay != 0 {
+		c.TcpNoDelay = config.TcpNoDelay
+	}
+	if config.TcpKeepAlive != 0 {
+		c.TcpKeepAlive = config.TcpKeepAlive
+	}
+	if config.TcpReadBuffer != 0 {
+		c.TcpReadBuffer = config.TcpReadBuffer
+	}
+	if config.TcpWriteBuffer != 0 {
+		c.TcpWriteBuffer = config.TcpWriteBuffer
+	}
+	if config.TcpLinger != 0 {
+		c.TcpLinger = config.TcpLinger
+	}
+	if config.TcpUserTimeout != 0 {
+		c.TcpUserTimeout = config.TcpUserTimeout
 	}
 
 	// Zinx
 	if config.Version != "" {
-		GlobalObject.Version = config.Version
+		c.Version = config.Version
 	}
 	if config.MaxPacketSize != 0 {
-		GlobalObject.MaxPacketSize = config.MaxPacketSize
+		c.MaxPacketSize = config.MaxPacketSize
 	}
 	if config.MaxConn != 0 {
-		GlobalObject.MaxConn = config.MaxConn
+		c.MaxConn = config.MaxConn
+	}
+	if config.MaxConnPerIP != 0 {
+		c.MaxConnPerIP = config.MaxConnPerIP
+	}
+	if config.AcceptRatePerIP != 0 {
+		c.AcceptRatePerIP = config.AcceptRatePerIP
+	}
+	if config.AcceptBurstPerIP != 0 {
+		c.AcceptBurstPerIP = config.AcceptBurstPerIP
 	}
 	if config.WorkerPoolSize != 0 {
-		GlobalObject.WorkerPoolSize = config.WorkerPoolSize
+		c.WorkerPoolSize = config.WorkerPoolSize
 	}
 	if config.MaxWorkerTaskLen != 0 {
-		GlobalObject.MaxWorkerTaskLen = config.MaxWorkerTaskLen
+		c.MaxWorkerTaskLen = config.MaxWorkerTaskLen
 	}
 	if config.WorkerMode != "" {
-		GlobalObject.WorkerMode = config.WorkerMode
+		c.WorkerMode = config.WorkerMode
 	}
 
 	if config.MaxMsgChanLen != 0 {
-		GlobalObject.MaxMsgChanLen = config.MaxMsgChanLen
+		c.MaxMsgChanLen = config.MaxMsgChanLen
 	}
 	if config.IOReadBuffSize != 0 {
-		GlobalObject.IOReadBuffSize = config.IOReadBuffSize
+		c.IOReadBuffSize = config.IOReadBuffSize
+	}
+	if config.HandlerTimeout != 0 {
+		c.HandlerTimeout = config.HandlerTimeout
+	}
+	if config.ConnIndexPolicy != "" {
+		c.ConnIndexPolicy = config.ConnIndexPolicy
 	}
 
 	// logger
 	// By default, it is False. If the config is not initialized, the default configuration will be used.
 	// (默认是False, config没有初始化即使用默认配置)
-	GlobalObject.LogIsolationLevel = config.LogIsolationLevel
-	if GlobalObject.LogIsolationLevel > glog.LogDebug {
-		glog.SetLogLevel(GlobalObject.LogIsolationLevel)
-	}
+	c.LogIsolationLevel = config.LogIsolationLevel
 
 	// Different from the required fields mentioned above, the logging module should use the default configuration if it is not configured.
 	// (不同于上方必填项 日志目前如果没配置应该使用默认配置)
 	if config.LogDir != "" {
-		GlobalObject.LogDir = config.LogDir
+		c.LogDir = config.LogDir
 	}
 
 	if config.LogFile != "" {
-		GlobalObject.LogFile = config.LogFile
-		glog.SetLogFile(GlobalObject.LogDir, GlobalObject.LogFile)
+		c.LogFile = config.LogFile
 	}
 
 	// Keepalive
 	if config.HeartbeatMax != 0 {
-		GlobalObject.HeartbeatMax = config.HeartbeatMax
+		c.HeartbeatMax = config.HeartbeatMax
 	}
 
 	// TLS
 	if config.CertFile != "" {
-		GlobalObject.CertFile = config.CertFile
+		c.CertFile = config.CertFile
 	}
 	if config.PrivateKeyFile != "" {
-		GlobalObject.PrivateKeyFile = config.PrivateKeyFile
+		c.PrivateKeyFile = config.PrivateKeyFile
+	}
+	if config.WsTLS {
+		c.WsTLS = config.WsTLS
+	}
+	if config.CertReloadInterval != 0 {
+		c.CertReloadInterval = config.CertReloadInterval
+	}
+	if config.CertReloadOnSIGHUP {
+		c.CertReloadOnSIGHUP = config.CertReloadOnSIGHUP
+	}
+	if config.ClientCAFile != "" {
+		c.ClientCAFile = config.ClientCAFile
+	}
+	if config.ClientAuth != "" {
+		c.ClientAuth = config.ClientAuth
+	}
+
+	// Admin
+	if config.AdminAddr != "" {
+		c.AdminAddr = config.AdminAddr
+	}
+	if config.AdminToken != "" {
+		c.AdminToken = config.AdminToken
+	}
+
+	// Metrics
+	if config.Metrics {
+		c.Metrics = config.Metrics
+	}
+	if config.MetricsAddr != "" {
+		c.MetricsAddr = config.MetricsAddr
+	}
+	if config.MetricsPath != "" {
+		c.MetricsPath = config.MetricsPath
+	}
+	if config.SessionGracePeriod != 0 {
+		c.SessionGracePeriod = config.SessionGracePeriod
+	}
+	if config.SessionReplayLen != 0 {
+		c.SessionReplayLen = config.SessionReplayLen
 	}
 
 	if config.Mode != "" {
-		GlobalObject.Mode = config.Mode
+		c.Mode = config.Mode
 	}
 	if config.WsPort != 0 {
-		GlobalObject.WsPort = config.WsPort
+		c.WsPort = config.WsPort
+	}
+	if config.ProxyProtocol {
+		c.ProxyProtocol = config.ProxyProtocol
+	}
+	if config.ProxyHeaderTimeout != 0 {
+		c.ProxyHeaderTimeout = config.ProxyHeaderTimeout
+	}
+	if config.ReusePort != 0 {
+		c.ReusePort = config.ReusePort
+	}
+	if config.WsPath != "" {
+		c.WsPath = config.WsPath
+	}
+
+	if len(config.Listeners) > 0 {
+		c.Listeners = config.Listeners
 	}
 
 	if config.RouterSlicesMode {
-		GlobalObject.RouterSlicesMode = config.RouterSlicesMode
+		c.RouterSlicesMode = config.RouterSlicesMode
 	}
 
 	if config.RequestPoolMode {
-		GlobalObject.RequestPoolMode = config.RequestPoolMode
+		c.RequestPoolMode = config.RequestPoolMode
+	}
+
+	if config.CallMode {
+		c.CallMode = config.CallMode
+	}
+
+	if config.UnixPath != "" {
+		c.UnixPath = config.UnixPath
 	}
 
 	if config.KcpPort != 0 {
-		GlobalObject.KcpPort = config.KcpPort
+		c.KcpPort = config.KcpPort
 	}
 
 	if config.KcpACKNoDelay {
-		GlobalObject.KcpACKNoDelay = config.KcpACKNoDelay
+		c.KcpACKNoDelay = config.KcpACKNoDelay
 	}
 
 	if !config.KcpStreamMode {
-		GlobalObject.KcpStreamMode = config.KcpStreamMode
+		c.KcpStreamMode = config.KcpStreamMode
 	}
 
 	if config.KcpNoDelay != 0 {
-		GlobalObject.KcpNoDelay = config.KcpNoDelay
+		c.KcpNoDelay = config.KcpNoDelay
 	}
 
 	if config.KcpInterval != 0 {
-		GlobalObject.KcpInterval = config.KcpInterval
+		c.KcpInterval = config.KcpInterval
 	}
 
 	if config.KcpResend != 0 {
-		GlobalObject.KcpResend = config.KcpResend
+		c.KcpResend = config.KcpResend
 	}
 
 	if config.KcpNc != 0 {
-		GlobalObject.KcpNc = config.KcpNc
+		c.KcpNc = config.KcpNc
 	}
 
 	if config.KcpSendWindow != 0 {
-		GlobalObject.KcpSendWindow = config.KcpSendWindow
+		c.KcpSendWindow = config.KcpSendWindow
 	}
 
 	if config.KcpRecvWindow != 0 {
-		GlobalObject.KcpRecvWindow = config.KcpRecvWindow
+		c.KcpRecvWindow = config.KcpRecvWindow
 	}
 
 	if config.KcpFecDataShards != 0 {
-		GlobalObject.KcpFecDataShards = config.KcpFecDataShards
+		c.KcpFecDataShards = config.KcpFecDataShards
 	}
 
 	if config.KcpFecParityShards != 0 {
-		GlobalObject.KcpFecParityShards = config.KcpFecParityShards
+		c.KcpFecParityShards = config.KcpFecParityShards
+	}
+
+	if config.KcpCrypt != "" {
+		c.KcpCrypt = config.KcpCrypt
+	}
+
+	if config.KcpKey != "" {
+		c.KcpKey = config.KcpKey
+	}
+
+	if config.KcpSalt != "" {
+		c.KcpSalt = config.KcpSalt
 	}
 
 }
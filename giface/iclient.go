@@ -9,6 +9,10 @@ type IClient interface {
 	AddRouter(msgID uint32, router IRouter)
 	Conn() IConnection
 
+	// Call makes a request/response call on the current connection, see IConnection.Call
+	// (在当前连接上发起请求/响应调用，见IConnection.Call)
+	Call(msgID uint32, data []byte, timeout time.Duration) (IMessage, error)
+
 	// SetOnConnStart Set the Hook function to be called when a connection is created for this Client
 	// (设置该Client的连接创建时Hook函数)
 	SetOnConnStart(func(IConnection))
@@ -52,7 +56,8 @@ type IClient interface {
 	// AddInterceptor Add an interceptor for this Client 添加拦截器
 	AddInterceptor(IInterceptor)
 
-	// Get the error channel for this Client 获取客户端错误管道
+	// Get the error channel for this Client, it stays the same across Restart and Stop and is never closed
+	// 获取客户端错误管道，Restart和Stop后仍为同一个管道且不会被关闭
 	GetErrChan() chan error
 
 	// Set the name of this Clien
@@ -62,4 +67,8 @@ type IClient interface {
 	// Get the name of this Client
 	// 获取客户端Client名称
 	GetName() string
+
+	// Get the token of the server session, empty unless session resumption is enabled
+	// 获取服务端会话令牌，未开启会话恢复时为空
+	GetSessionToken() string
 }
@@ -2,7 +2,10 @@ package giface
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -27,6 +30,14 @@ type IConnection interface {
 	LocalAddrString() string    // Get the local address information of the connection as a string
 	RemoteAddrString() string   // Get the remote address information of the connection as a string
 
+	// Get the verified certificate chain of the peer (leaf first), nil if it is not a TLS connection or the peer is not verified
+	// (获取对端经过校验的证书链，叶子证书在前，非TLS连接或对端未经校验时为nil)
+	GetPeerCertificates() []*x509.Certificate
+
+	// Get the TLS connection state, ok is false if it is not a TLS connection
+	// (获取TLS连接状态，非TLS连接时ok为false)
+	GetTLSConnectionState() (state tls.ConnectionState, ok bool)
+
 	Send(data []byte) error        // Send data directly to the remote TCP client (without buffering)
 	SendToQueue(data []byte) error // Send data to the message queue to be sent to the remote TCP client later
 
@@ -38,6 +49,13 @@ type IConnection interface {
 	// 直接将Message数据发送给远程的TCP客户端(有缓冲)
 	SendBuffMsg(msgID uint32, data []byte) error
 
+	// Call sends data to msgID of the peer and waits for the IRequest.Reply of its handler, both peers must set
+	// gconf.Config.CallMode. It fails with gnet.ErrCallTimeout after timeout (timeout <= 0 waits forever) and
+	// fails at once when the connection closes.
+	// (向对端的msgID发送data并等待其处理函数通过IRequest.Reply返回的应答，双方都需设置gconf.Config.CallMode。
+	// 超过timeout返回gnet.ErrCallTimeout(timeout<=0表示一直等待)，连接关闭时立即返回错误)
+	Call(msgID uint32, data []byte, timeout time.Duration) (IMessage, error)
+
 	SetProperty(key string, value interface{})   // Set connection property
 	GetProperty(key string) (interface{}, error) // Get connection property
 	RemoveProperty(key string)                   // Remove connection property
@@ -0,0 +1,8 @@
+package giface
+
+// IConnIDGenerator generates the IDs of the connections accepted by a server, it is called concurrently
+// by all the listeners of the server and must never return the same ID twice while the connection lives
+// (生成服务器所接收连接的ID，会被服务器的所有监听并发调用，连接存活期间不能返回重复的ID)
+type IConnIDGenerator interface {
+	NextID() uint64
+}
@@ -11,4 +11,12 @@ type IConnManager interface {
 	GetAllConnIDStr() []string
 	Range(func(uint64, IConnection, interface{}) error, interface{}) error
 	Range2(func(string, IConnection, interface{}) error, interface{}) error
+
+	// BindIndex binds key of index (e.g. index "user" and a user ID) to conn, the binding is removed when conn is removed.
+	// A key already bound to another connection is handled by the ConnIndexPolicy of the config.
+	// (将index的key(例如索引"user"和用户ID)绑定到conn，conn被移除时自动解绑。key已绑定其他连接时按配置的ConnIndexPolicy处理)
+	BindIndex(index string, key string, conn IConnection) error
+	UnbindIndex(index string, key string, conn IConnection)   // Unbind key of index from conn (解除conn与index的key的绑定)
+	GetByIndex(index string, key string) (IConnection, error) // Get the latest connection bound to key of index (获取最近绑定到index的key的连接)
+	GetAllByIndex(index string, key string) []IConnection     // Get all the connections bound to key of index (获取绑定到index的key的全部连接)
 }
@@ -0,0 +1,19 @@
+package giface
+
+// IGroupManager keeps connections in named groups (rooms), a connection leaves all its groups when it closes
+// (按名称将连接分组(房间)管理，连接关闭时自动离开所有分组)
+type IGroupManager interface {
+	Join(group string, conn IConnection) error // Add conn to group, fails if conn is closed (将连接加入分组，连接已关闭时返回错误)
+	Leave(group string, conn IConnection)      // Remove conn from group (将连接移出分组)
+	LeaveAll(conn IConnection)                 // Remove conn from all its groups (将连接移出其所在的全部分组)
+	Members(group string) []IConnection        // Get the connections of group (获取分组中的连接)
+	Count(group string) int                    // Get the number of connections in group (获取分组中的连接数量)
+	Groups(conn IConnection) []string          // Get the groups conn belongs to (获取连接所在的分组)
+
+	// Broadcast packs the message once and puts it into the send queue of every connection in group, except the
+	// connections whose IDs are in exclude. It does not wait for full send queues, the message is dropped for those
+	// connections. It returns the IDs of the connections that failed and an error joining their errors.
+	// (消息只封包一次，然后放入分组中除exclude以外每个连接的发送队列。不会等待已满的发送队列，这些连接的消息被丢弃。
+	// 返回发送失败的连接ID，以及合并了这些连接错误的error)
+	Broadcast(group string, msgID uint32, data []byte, exclude ...uint64) ([]uint64, error)
+}
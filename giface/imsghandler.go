@@ -1,14 +1,27 @@
 package giface
 
+import (
+	"context"
+	"time"
+)
+
 type IMsgHandler interface {
 	AddRouter(msgID uint32, router IRouter)
 	AddRouterSlices(msgID uint32, hander ...RouterHandler) IRouterSlices
 	Group(start, end uint32, handers ...RouterHandler) IGroupRouterSlices
 	Use(handers ...RouterHandler) IRouterSlices
 
+	// Set the deadline of the request context in the handlers of msgID, a negative timeout removes the deadline
+	// (设置msgID处理函数中请求context的截止时间，负数表示不限制)
+	SetHandlerTimeout(msgID uint32, timeout time.Duration)
+
 	StartWorkerPool()
 	SendMsgToTaskQueue(request IRequest)
 
+	// Drain stops dispatching new requests and waits until the dispatched ones have been handled
+	// (停止分发新请求，并等待已分发的请求处理完毕)
+	Drain(ctx context.Context) error
+
 	Execute(request IRequest)
 
 	AddInterceptor(interceptor IInterceptor)
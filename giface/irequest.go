@@ -1,5 +1,10 @@
 package giface
 
+import (
+	"context"
+	"errors"
+)
+
 type HandleStep int
 
 type IFuncRequest interface {
@@ -9,11 +14,20 @@ type IFuncRequest interface {
 type IRequest interface {
 	GetConnection() IConnection
 
+	// Context returns the context of the request, cancelled when the connection stops, the handler returns
+	// or the handler deadline passes, it carries connID and msgID for the glog FX methods
+	// (返回请求的context，连接停止、处理函数返回或超过处理截止时间时被取消，携带供glog FX方法输出的connID和msgID)
+	Context() context.Context
+
 	GetData() []byte
 	GetMsgID() uint32
 
 	GetMessage() IMessage
 
+	// Reply sends data back as the reply of a request made with IConnection.Call, it fails for other requests
+	// (将data作为IConnection.Call发起的请求的应答发回，其他请求返回错误)
+	Reply(data []byte) error
+
 	GetResPonse() IcResp
 	SetResPonse(IcResp)
 
@@ -34,9 +48,11 @@ type IRequest interface {
 type BaseRequest struct{}
 
 func (br *BaseRequest) GetConnection() IConnection       { return nil }
+func (br *BaseRequest) Context() context.Context         { return context.Background() }
 func (br *BaseRequest) GetData() []byte                  { return nil }
 func (br *BaseRequest) GetMsgID() uint32                 { return 0 }
 func (br *BaseRequest) GetMessage() IMessage             { return nil }
+func (br *BaseRequest) Reply(data []byte) error          { return errors.New("reply not supported") }
 func (br *BaseRequest) GetResponse() IcResp              { return nil }
 func (br *BaseRequest) SetResponse(resp IcResp)          {}
 func (br *BaseRequest) BindRouter(router IRouter)        {}
@@ -1,6 +1,8 @@
 package giface
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"time"
 )
@@ -10,12 +12,50 @@ type IServer interface {
 	Stop()
 	Serve()
 
+	// Shutdown gracefully stops the server: stop accepting, finish in-flight handlers, flush send queues, then close connections
+	// (优雅关闭：停止监听，等待处理中的请求完成，清空发送队列后关闭连接)
+	Shutdown(ctx context.Context) error
+
+	// GracefulRestart hands the listening sockets to a freshly exec'd copy of the process and, once the copy reports it
+	// has taken them over, shuts this server down; if the copy fails this server keeps serving. A server serving KCP
+	// can not be restarted this way, its UDP socket can not be shared while the KCP sessions drain
+	// (将监听socket交给新启动的进程副本，副本报告接管完成后优雅关闭本服务；副本失败时本服务继续运行。
+	// 提供KCP服务的服务器不能以这种方式重启，KCP会话排空期间其UDP socket无法共享)
+	GracefulRestart(ctx context.Context) error
+
 	AddRouter(msgID uint32, router IRouter)
 	AddRouterSlices(msgID uint32, handlers ...RouterHandler) IRouterSlices
 	Group(start, end uint32, handlers ...RouterHandler) IGroupRouterSlices
 	Use(handlers ...RouterHandler) IRouterSlices
 
-	GetConnMgr() IConnManager //得到链接管理
+	// Set the deadline of the request context in the handlers of msgID, overriding the HandlerTimeout of the config
+	// (设置msgID处理函数中请求context的截止时间，覆盖配置中的HandlerTimeout)
+	SetHandlerTimeout(msgID uint32, timeout time.Duration)
+
+	GetConnMgr() IConnManager   //得到链接管理
+	GetGroupMgr() IGroupManager //得到连接分组(房间)管理
+
+	// Get the resumable sessions, nil if SessionGracePeriod of the config is not set
+	// (得到可恢复的会话管理，配置中未设置SessionGracePeriod时为nil)
+	GetSessionMgr() ISessionManager
+
+	// AddListener adds a listener of the given mode ("tcp", "websocket", "kcp", "unix") before Start,
+	// all listeners share the same MsgHandler and ConnManager. For "unix" host is the socket path
+	// (在Start之前添加一个监听，所有监听共用同一个MsgHandler和ConnManager)
+	AddListener(mode string, host string, port int)
+
+	// Serve a listener or packet conn bound by the caller until the server stops, Start does not need to be called first
+	// (在调用方绑定的监听或PacketConn上提供服务直到服务停止，无需先调用Start)
+	ServeListener(listener net.Listener) error
+	ServeWebsocketListener(listener net.Listener, path string) error
+	ServeKcpPacketConn(conn net.PacketConn) error
+
+	// The address the first listener of each transport is bound to, nil before it is listening
+	// (各传输协议第一个监听实际绑定的地址，监听前为nil)
+	TcpAddr() net.Addr
+	WsAddr() net.Addr
+	KcpAddr() net.Addr
+	UnixAddr() net.Addr
 
 	SetOnConnStart(func(IConnection))  //设置该Server的连接创建时Hook函数
 	SetOnConnStop(func(IConnection))   //设置该Server的连接断开时的Hook函数
@@ -28,6 +68,11 @@ type IServer interface {
 
 	SetPacket(IDataPack) //设置Server绑定的数据协议封包方式
 
+	// Replace the auto-increment connection IDs, e.g. with gnet.NewSnowflakeConnIDGenerator for IDs unique across nodes
+	// (替换自增的连接ID，例如使用gnet.NewSnowflakeConnIDGenerator生成跨节点唯一的ID)
+	SetConnIDGenerator(IConnIDGenerator)
+	GetConnIDGenerator() IConnIDGenerator
+
 	StartHeartBeat(time.Duration)                             //启动心跳检测
 	StartHeartBeatWithOption(time.Duration, *HeartBeatOption) //启动心跳检测(自定义回调)
 	GetHeartBeat() IHeartbeatChecker                          //获取心跳检测器
@@ -40,6 +85,25 @@ type IServer interface {
 	// (添加websocket认证方法)
 	SetWebsocketAuth(func(r *http.Request) error)
 
+	// Get the http.Handler that upgrades requests to websocket connections, it can be mounted under any HTTP server
+	// without calling Start
+	// (获取将请求升级为websocket连接的http.Handler，无需调用Start即可挂载到任意HTTP服务下)
+	WebsocketHandler() http.Handler
+
+	// Get the http.Handler of the admin endpoints serving JSON about connections, workers and routes
+	// (获取提供连接、worker和路由JSON信息的管理接口http.Handler)
+	AdminHandler() http.Handler
+	// The address the admin listener is bound to, nil if it is not listening
+	// (管理监听实际绑定的地址，未监听时为nil)
+	AdminAddr() net.Addr
+
+	// Get the http.Handler serving the connection, message and worker metrics in the Prometheus text format
+	// (获取以Prometheus文本格式输出连接、消息和worker指标的http.Handler)
+	MetricsHandler() http.Handler
+	// The address the metrics listener is bound to, nil if it is not listening
+	// (指标监听实际绑定的地址，未监听时为nil)
+	MetricsAddr() net.Addr
+
 	// Get the server name (获取服务器名称)
 	ServerName() string
 }
@@ -0,0 +1,29 @@
+package giface
+
+// ISession outlives the connection it is attached to, a reconnecting client presents its token to resume it
+// (会话比其绑定的连接存活更久，重连的客户端出示会话令牌即可恢复会话)
+type ISession interface {
+	GetToken() string  // Get the resume token of the session (获取会话的恢复令牌)
+	Conn() IConnection // Get the attached connection, nil while the client is away (获取绑定的连接，客户端断开期间为nil)
+
+	// SendBuffMsg sends to the attached connection, while the client is away the message is kept in the
+	// bounded replay buffer and sent once the session is resumed. Only messages sent through the session
+	// are buffered, those sent with the SendBuffMsg of a connection are lost while the client is away
+	// (发送给绑定的连接，客户端断开期间消息保存在有界的重放缓冲中，会话恢复后再发送。只有通过会话发送的消息会被缓存，
+	// 直接通过连接的SendBuffMsg发送的消息在客户端断开期间会丢失)
+	SendBuffMsg(msgID uint32, data []byte) error
+}
+
+type ISessionManager interface {
+	Get(token string) (ISession, error)           // Get a session by its token (通过令牌获取会话)
+	SessionOf(conn IConnection) (ISession, error) // Get the session attached to conn (获取连接绑定的会话)
+	Len() int                                     // Get the number of sessions, including the detached ones (获取会话数量，包括已断开的)
+}
+
+const (
+	// The server sends the session token to the client on this msgID when a connection starts and when a session is resumed
+	// (连接建立及会话恢复时，服务端通过该消息ID将会话令牌发给客户端)
+	SessionTokenMsgID uint32 = 99998
+	// The client sends the token of its previous session on this msgID to resume it (客户端通过该消息ID发送上一个会话的令牌以恢复会话)
+	SessionResumeMsgID uint32 = 99997
+)
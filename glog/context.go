@@ -0,0 +1,75 @@
+package glog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ctxFieldsKey is the context key of the log fields (日志字段在context中的键)
+type ctxFieldsKey struct{}
+
+// ctxField is a log field, the fields of a context form a list from the newest to the oldest
+// (日志字段，context中的字段从新到旧组成链表)
+type ctxField struct {
+	key    string
+	value  interface{}
+	parent *ctxField
+}
+
+// WithField returns a child of ctx carrying a log field, the FX methods of the default logger
+// print the fields of their ctx in front of the message
+// (返回携带一个日志字段的子context，默认日志的FX方法会在消息前输出ctx中的字段)
+func WithField(ctx context.Context, key string, value interface{}) context.Context {
+	parent, _ := ctx.Value(ctxFieldsKey{}).(*ctxField)
+	return context.WithValue(ctx, ctxFieldsKey{}, &ctxField{key: key, value: value, parent: parent})
+}
+
+// Fields returns the log fields carried by ctx, so a custom logger set by SetLogger can output them.
+// A newer field overrides an older one of the same key
+// (返回ctx携带的日志字段，供SetLogger设置的自定义日志输出，新字段覆盖同名的旧字段)
+func Fields(ctx context.Context) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if ctx == nil {
+		return fields
+	}
+	for f, _ := ctx.Value(ctxFieldsKey{}).(*ctxField); f != nil; f = f.parent {
+		if _, ok := fields[f.key]; !ok {
+			fields[f.key] = f.value
+		}
+	}
+	return fields
+}
+
+// formatFields formats the log fields of ctx as "[k1=v1 k2=v2] " from the oldest to the newest, "" if there is none
+// (将ctx的日志字段按从旧到新的顺序格式化为"[k1=v1 k2=v2] "，没有字段时返回"")
+func formatFields(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	var fields []*ctxField
+	seen := make(map[string]struct{})
+	for f, _ := ctx.Value(ctxFieldsKey{}).(*ctxField); f != nil; f = f.parent {
+		// A newer field overrides an older one of the same key (新字段覆盖同名的旧字段)
+		if _, ok := seen[f.key]; ok {
+			continue
+		}
+		seen[f.key] = struct{}{}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('[')
+	for i := len(fields) - 1; i >= 0; i-- {
+		if i != len(fields)-1 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", fields[i].key, fields[i].value)
+	}
+	b.WriteString("] ")
+	return b.String()
+}
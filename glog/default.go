@@ -2,7 +2,6 @@ package glog
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/liyee/gray/giface"
 )
@@ -24,18 +23,15 @@ func (log *grayDefaultLog) DebugF(format string, v ...interface{}) {
 }
 
 func (log *grayDefaultLog) InfoFX(ctx context.Context, format string, v ...interface{}) {
-	fmt.Println(ctx)
-	StdZinxLog.Infof(format, v...)
+	StdZinxLog.Infof("%s"+format, append([]interface{}{formatFields(ctx)}, v...)...)
 }
 
 func (log *grayDefaultLog) ErrorFX(ctx context.Context, format string, v ...interface{}) {
-	fmt.Println(ctx)
-	StdZinxLog.Errorf(format, v...)
+	StdZinxLog.Errorf("%s"+format, append([]interface{}{formatFields(ctx)}, v...)...)
 }
 
 func (log *grayDefaultLog) DebugFX(ctx context.Context, format string, v ...interface{}) {
-	fmt.Println(ctx)
-	StdZinxLog.Debugf(format, v...)
+	StdZinxLog.Debugf("%s"+format, append([]interface{}{formatFields(ctx)}, v...)...)
 }
 
 func SetLogger(newlog giface.ILogger) {
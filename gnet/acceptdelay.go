@@ -1,6 +1,9 @@
 package gnet
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 const (
 	maxDelay = 1 * time.Second
@@ -12,7 +15,10 @@ func init() {
 	AcceptDelay = &acceptDelay{duration: 0}
 }
 
+// acceptDelay is shared by the websocket handlers serving concurrently, so duration is protected by lock
+// (acceptDelay被并发执行的websocket处理函数共用，因此duration由lock保护)
 type acceptDelay struct {
+	lock     sync.Mutex
 	duration time.Duration
 }
 
@@ -22,10 +28,16 @@ func (d *acceptDelay) Delay() {
 }
 
 func (d *acceptDelay) Reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
 	d.duration = 0
 }
 
 func (d *acceptDelay) Up() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
 	if d.duration == 0 {
 		d.duration = 5 * time.Millisecond
 		return
@@ -36,8 +48,16 @@ func (d *acceptDelay) Up() {
 	}
 }
 
+// current returns the current delay (返回当前的延迟)
+func (d *acceptDelay) current() time.Duration {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.duration
+}
+
 func (d *acceptDelay) do() {
-	if d.duration > 0 {
-		time.Sleep(d.duration)
+	if duration := d.current(); duration > 0 {
+		time.Sleep(duration)
 	}
 }
@@ -0,0 +1,329 @@
+package gnet
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/glog"
+)
+
+// adminMode is the key of the admin listener address (管理监听地址的键)
+const adminMode = "admin"
+
+// inspectable is implemented by the connections that expose their state to the admin endpoints
+// (向管理接口暴露内部状态的连接实现该接口)
+type inspectable interface {
+	GetProperties() map[string]interface{}
+	LastActivity() time.Time
+	SendQueueLen() (int, int)
+}
+
+// adminServer is the summary of the running server (运行中服务的概要信息)
+type adminServer struct {
+	Name           string              `json:"name"`
+	Addrs          map[string][]string `json:"addrs"`
+	Connections    int                 `json:"connections"`
+	MaxConn        int                 `json:"max_conn"`
+	WorkerPoolSize uint32              `json:"worker_pool_size"`
+	WorkerMode     string              `json:"worker_mode"`
+	IPLimiter      *IPLimiterStats     `json:"ip_limiter,omitempty"`
+}
+
+// adminConn is the state of a connection, the ID is a string because snowflake IDs exceed the safe integers of JSON
+// (连接的状态，ID使用字符串，因为雪花算法ID超出了JSON的安全整数范围)
+type adminConn struct {
+	ConnID       string            `json:"conn_id"`
+	Transport    string            `json:"transport"`
+	RemoteAddr   string            `json:"remote_addr"`
+	LocalAddr    string            `json:"local_addr"`
+	WorkerID     uint32            `json:"worker_id"`
+	Properties   map[string]string `json:"properties"`
+	LastActivity *time.Time        `json:"last_activity,omitempty"`
+	IdleSeconds  float64           `json:"idle_seconds,omitempty"`
+	SendQueueLen int               `json:"send_queue_len"`
+	SendQueueCap int               `json:"send_queue_cap"`
+}
+
+// adminWorkers is the task queue depth of every worker (每个worker的任务队列深度)
+type adminWorkers struct {
+	WorkerMode string        `json:"worker_mode"`
+	PoolSize   uint32        `json:"pool_size"`
+	Pending    int64         `json:"pending"`
+	Workers    []adminWorker `json:"workers"`
+}
+
+type adminWorker struct {
+	WorkerID int `json:"worker_id"`
+	QueueLen int `json:"queue_len"`
+	QueueCap int `json:"queue_cap"`
+}
+
+// adminRoutes lists the registered msgIDs (已注册的消息ID)
+type adminRoutes struct {
+	Routers      []uint32           `json:"routers"`
+	RouterSlices []adminRouterSlice `json:"router_slices"`
+}
+
+type adminRouterSlice struct {
+	MsgID    uint32 `json:"msg_id"`
+	Handlers int    `json:"handlers"`
+}
+
+// AdminHandler returns the http.Handler of the admin endpoints, it can be mounted under any HTTP server
+// with http.StripPrefix. The endpoints are:
+//
+//	GET  /                       the server summary
+//	GET  /connections            the connections, ?limit=N returns the first N by ID
+//	GET  /connections/{id}       one connection
+//	POST /connections/{id}/kick  stop a connection, only served when AdminToken is set
+//	GET  /workers                the task queue depth of every worker
+//	GET  /routes                 the registered msgIDs
+//	GET  /metrics                the metrics in the Prometheus text format
+//
+// (返回管理接口的http.Handler，可以通过http.StripPrefix挂载到任意HTTP服务下)
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.adminServer)
+	mux.HandleFunc("GET /connections", s.adminConnections)
+	mux.HandleFunc("GET /connections/{id}", s.adminConnection)
+	mux.HandleFunc("GET /workers", s.adminWorkers)
+	mux.HandleFunc("GET /routes", s.adminRoutes)
+	mux.Handle("GET /metrics", s.MetricsHandler())
+
+	token := s.config.AdminToken
+	if token == "" {
+		// Without a token the endpoints are read-only, anyone reaching them must not be able to stop connections
+		// (未设置令牌时接口只读，能访问接口的人不能停止连接)
+		glog.Ins().InfoF("[START] admin token is not set, the kick endpoint is disabled")
+		return mux
+	}
+	mux.HandleFunc("POST /connections/{id}/kick", s.adminKick)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// AdminAddr returns the address the admin listener is bound to, nil before it is listening
+// (返回管理监听实际绑定的地址，监听前为nil)
+func (s *Server) AdminAddr() net.Addr {
+	return s.addr(adminMode)
+}
+
+// listenAdmin serves the admin endpoints on addr until the server stops
+// (在addr上提供管理接口，直到服务停止)
+func (s *Server) listenAdmin(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		glog.Ins().ErrorF("[START] admin listen %s err: %v", addr, err)
+		return
+	}
+	s.addAddr(adminMode, listener.Addr())
+	glog.Ins().InfoF("[START] admin server name: %s, listening at %s", s.Name, listener.Addr())
+
+	httpServer := &http.Server{Handler: s.AdminHandler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			glog.Ins().ErrorF("admin serve err: %v", err)
+		}
+	}()
+
+	<-s.exitChan
+	_ = httpServer.Close()
+}
+
+func (s *Server) adminServer(w http.ResponseWriter, r *http.Request) {
+	summary := adminServer{
+		Name:           s.Name,
+		Addrs:          make(map[string][]string),
+		Connections:    s.ConnMgr.Len(),
+		MaxConn:        s.config.MaxConn,
+		WorkerPoolSize: s.config.WorkerPoolSize,
+		WorkerMode:     s.config.WorkerMode,
+	}
+
+	s.addrLock.RLock()
+	for mode, addrs := range s.addrs {
+		for _, addr := range addrs {
+			summary.Addrs[mode] = append(summary.Addrs[mode], addr.String())
+		}
+	}
+	s.addrLock.RUnlock()
+
+	if s.ipLimiter.Enabled() {
+		stats := s.ipLimiter.Stats()
+		summary.IPLimiter = &stats
+	}
+
+	writeAdminJSON(w, http.StatusOK, summary)
+}
+
+func (s *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	limit := -1
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = n
+	}
+
+	ids := s.ConnMgr.GetAllConnID()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	conns := make([]adminConn, 0, len(ids))
+	for _, id := range ids {
+		if limit >= 0 && len(conns) >= limit {
+			break
+		}
+		// The connection may be closed after the IDs were taken (获取ID之后连接可能已经关闭)
+		conn, err := s.ConnMgr.Get(id)
+		if err != nil {
+			continue
+		}
+		conns = append(conns, newAdminConn(conn))
+	}
+
+	writeAdminJSON(w, http.StatusOK, conns)
+}
+
+func (s *Server) adminConnection(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.ConnMgr.Get2(r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, newAdminConn(conn))
+}
+
+func (s *Server) adminKick(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.ConnMgr.Get2(r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+
+	glog.Ins().InfoF("[ADMIN] kick connection ConnID = %s, RemoteAddr = %s", conn.GetConnIdStr(), conn.RemoteAddrString())
+	conn.Stop()
+
+	writeAdminJSON(w, http.StatusOK, map[string]string{"conn_id": conn.GetConnIdStr()})
+}
+
+func (s *Server) adminWorkers(w http.ResponseWriter, r *http.Request) {
+	mh, ok := s.msgHandler.(*MsgHandler)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, errors.New("the msg handler does not expose its workers"))
+		return
+	}
+
+	workers := adminWorkers{
+		WorkerMode: mh.config.WorkerMode,
+		PoolSize:   mh.WorkerPoolSize,
+		Pending:    atomic.LoadInt64(&mh.pending),
+		Workers:    make([]adminWorker, 0, len(mh.TaskQueue)),
+	}
+	for i, queue := range mh.TaskQueue {
+		workers.Workers = append(workers.Workers, adminWorker{WorkerID: i, QueueLen: len(queue), QueueCap: cap(queue)})
+	}
+
+	writeAdminJSON(w, http.StatusOK, workers)
+}
+
+func (s *Server) adminRoutes(w http.ResponseWriter, r *http.Request) {
+	mh, ok := s.msgHandler.(*MsgHandler)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, errors.New("the msg handler does not expose its routes"))
+		return
+	}
+
+	routes := adminRoutes{
+		Routers:      make([]uint32, 0, len(mh.Apis)),
+		RouterSlices: make([]adminRouterSlice, 0, len(mh.RouterSlices.Apis)),
+	}
+	for msgID := range mh.Apis {
+		routes.Routers = append(routes.Routers, msgID)
+	}
+	for msgID, handlers := range mh.RouterSlices.Apis {
+		routes.RouterSlices = append(routes.RouterSlices, adminRouterSlice{MsgID: msgID, Handlers: len(handlers)})
+	}
+	sort.Slice(routes.Routers, func(i, j int) bool { return routes.Routers[i] < routes.Routers[j] })
+	sort.Slice(routes.RouterSlices, func(i, j int) bool { return routes.RouterSlices[i].MsgID < routes.RouterSlices[j].MsgID })
+
+	writeAdminJSON(w, http.StatusOK, routes)
+}
+
+// newAdminConn collects the state of a connection, property values are formatted with fmt so any type can be shown
+// (收集连接的状态，属性值使用fmt格式化，以便显示任意类型)
+func newAdminConn(conn giface.IConnection) adminConn {
+	info := adminConn{
+		ConnID:     conn.GetConnIdStr(),
+		Transport:  transportOf(conn),
+		RemoteAddr: conn.RemoteAddrString(),
+		LocalAddr:  conn.LocalAddrString(),
+		WorkerID:   conn.GetWorkerID(),
+	}
+
+	if c, ok := conn.(inspectable); ok {
+		properties := c.GetProperties()
+		info.Properties = make(map[string]string, len(properties))
+		for k, v := range properties {
+			info.Properties[k] = fmt.Sprint(v)
+		}
+		// Omitted until the first data is received (收到第一个数据之前省略)
+		if lastActivity := c.LastActivity(); !lastActivity.IsZero() {
+			info.LastActivity = &lastActivity
+			info.IdleSeconds = time.Since(lastActivity).Seconds()
+		}
+		info.SendQueueLen, info.SendQueueCap = c.SendQueueLen()
+	}
+	return info
+}
+
+// transportOf returns the transport of a connection, see the gconf.ServerMode* constants
+// (返回连接的传输协议，见gconf.ServerMode*常量)
+func transportOf(conn giface.IConnection) string {
+	switch c := conn.(type) {
+	case *WsConnection:
+		return gconf.ServerModeWebSocket
+	case *KcpConnection:
+		return gconf.ServerModeKcp
+	case *Connection:
+		if c.LocalAddr() != nil && c.LocalAddr().Network() == "unix" {
+			return gconf.ServerModeUnix
+		}
+		return gconf.ServerModeTcp
+	default:
+		return ""
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		glog.Ins().ErrorF("admin write response err: %v", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,53 @@
+package gnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liyee/gray/gconf"
+)
+
+func TestAdminKickRequiresToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		auth    string
+		method  string
+		want    int
+		handled bool // the request reached an admin handler (请求到达了管理接口的处理函数)
+	}{
+		{name: "no token kick disabled", method: http.MethodPost, want: http.StatusNotFound},
+		{name: "no token read allowed", method: http.MethodGet, want: http.StatusNotFound, handled: true},
+		{name: "wrong token", token: "secret", auth: "Bearer nope", method: http.MethodPost, want: http.StatusUnauthorized, handled: true},
+		{name: "missing auth", token: "secret", method: http.MethodGet, want: http.StatusUnauthorized, handled: true},
+		{name: "kick allowed", token: "secret", auth: "Bearer secret", method: http.MethodPost, want: http.StatusNotFound, handled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUserConfServer(&gconf.Config{AdminToken: tt.token}).(*Server)
+
+			path := "/connections/42"
+			if tt.method == http.MethodPost {
+				path += "/kick"
+			}
+			req := httptest.NewRequest(tt.method, path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			s.AdminHandler().ServeHTTP(rec, req)
+
+			// Connection 42 does not exist, the admin handlers answer 404 in JSON unlike the mux
+			// (连接42不存在，与mux不同，管理接口的处理函数以JSON返回404)
+			if rec.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d", tt.method, path, rec.Code, tt.want)
+			}
+			if handled := strings.Contains(rec.Header().Get("Content-Type"), "json"); handled != tt.handled {
+				t.Fatalf("%s %s handled = %v, want %v", tt.method, path, handled, tt.handled)
+			}
+		})
+	}
+}
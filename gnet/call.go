@@ -0,0 +1,151 @@
+package gnet
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/glog"
+	"github.com/liyee/gray/gpack"
+)
+
+var (
+	// ErrCallTimeout is returned by Call when no reply arrives in time (Call在规定时间内未收到应答时返回)
+	ErrCallTimeout = errors.New("call timeout")
+	// ErrCallConnClosed is returned by Call when the connection closes before the reply arrives
+	// (Call在收到应答前连接已关闭时返回)
+	ErrCallConnClosed = errors.New("connection closed before the reply of the call")
+)
+
+// callResolver is implemented by the connections that can make calls, the msg handler hands replies to it
+// (可以发起调用的连接实现该接口，消息处理器将应答交给它)
+type callResolver interface {
+	resolveCall(corrID uint32, msg giface.IMessage) bool
+}
+
+// callTable holds the pending calls of a connection keyed by correlation ID (按关联ID保存连接上尚未应答的调用)
+type callTable struct {
+	nextID  uint32
+	lock    sync.Mutex
+	pending map[uint32]chan giface.IMessage
+}
+
+// call sends data to msgID as a call on conn and waits for the reply, a timeout <= 0 waits until the connection closes
+// (在conn上以调用的方式向msgID发送data并等待应答，timeout<=0时一直等到连接关闭)
+func (t *callTable) call(conn giface.IConnection, msgID uint32, data []byte, timeout time.Duration) (giface.IMessage, error) {
+	if !configOf(conn).CallMode {
+		return nil, errors.New("calls are not enabled, set CallMode in the config of both peers")
+	}
+	if msgID&^gpack.MsgIDMask != 0 {
+		return nil, errors.New("msgID overlaps the call flags")
+	}
+	ctx := conn.Context()
+	if ctx == nil {
+		return nil, errors.New("connection not started when call")
+	}
+
+	corrID, reply := t.add()
+	defer t.remove(corrID)
+
+	if err := conn.SendMsg(msgID|gpack.MsgIDCallFlag, gpack.WithCorrelationID(corrID, data)); err != nil {
+		return nil, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case msg := <-reply:
+		return msg, nil
+	case <-expired:
+		return nil, ErrCallTimeout
+	case <-ctx.Done():
+		return nil, ErrCallConnClosed
+	}
+}
+
+func (t *callTable) add() (uint32, chan giface.IMessage) {
+	corrID := atomic.AddUint32(&t.nextID, 1)
+	if corrID == 0 {
+		// 0 means the request is not a call (0表示请求不是调用)
+		corrID = atomic.AddUint32(&t.nextID, 1)
+	}
+	reply := make(chan giface.IMessage, 1)
+
+	t.lock.Lock()
+	if t.pending == nil {
+		t.pending = make(map[uint32]chan giface.IMessage)
+	}
+	t.pending[corrID] = reply
+	t.lock.Unlock()
+
+	return corrID, reply
+}
+
+func (t *callTable) remove(corrID uint32) {
+	t.lock.Lock()
+	delete(t.pending, corrID)
+	t.lock.Unlock()
+}
+
+// resolve hands msg to the call waiting for corrID, false means no call is waiting (e.g. it timed out)
+// (将msg交给等待corrID的调用，返回false表示没有调用在等待，例如已超时)
+func (t *callTable) resolve(corrID uint32, msg giface.IMessage) bool {
+	t.lock.Lock()
+	reply, ok := t.pending[corrID]
+	delete(t.pending, corrID)
+	t.lock.Unlock()
+
+	if ok {
+		reply <- msg
+	}
+	return ok
+}
+
+// routeCall strips the correlation ID of calls and replies, a call remembers it for Reply, a reply is handed to
+// the pending call of the connection. It returns false if the request must not be dispatched to the routers.
+// It only runs with CallMode, otherwise every msgID is routed as is.
+// (去掉调用和应答的关联ID，调用会记住它以便Reply，应答则交给连接上等待的调用。返回false表示请求不应分发给路由。
+// 只在开启CallMode时执行，否则所有msgID按原样路由)
+func routeCall(request giface.IRequest) bool {
+	msg := request.GetMessage()
+	if msg == nil {
+		return true
+	}
+	flags := msg.GetMsgID() &^ gpack.MsgIDMask
+	if flags == 0 {
+		return true
+	}
+
+	corrID, payload, err := gpack.SplitCorrelationID(msg.GetData())
+	if err != nil {
+		glog.Ins().ErrorF("drop msgID = %d, err: %v", msg.GetMsgID(), err)
+		return false
+	}
+	msgID := msg.GetMsgID() & gpack.MsgIDMask
+
+	if flags&gpack.MsgIDReplyFlag != 0 {
+		// The read buffer may be reused, the caller gets its own copy of the payload
+		// (读缓冲区可能被复用，调用方得到一份独立的数据副本)
+		reply := gpack.NewMsgPackage(msgID, append([]byte(nil), payload...))
+		resolver, ok := request.GetConnection().(callResolver)
+		if !ok || !resolver.resolveCall(corrID, reply) {
+			glog.Ins().DebugF("drop the reply of msgID = %d, no call is waiting for it", msgID)
+		}
+		return false
+	}
+
+	msg.SetMsgID(msgID)
+	msg.SetData(payload)
+	msg.SetDataLen(uint32(len(payload)))
+	if req, ok := request.(*Request); ok {
+		req.callID = corrID
+	}
+	return true
+}
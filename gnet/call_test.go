@@ -0,0 +1,154 @@
+package gnet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/gpack"
+)
+
+func TestRouteCall(t *testing.T) {
+	tests := []struct {
+		name         string
+		msgID        uint32
+		data         []byte
+		wantDispatch bool
+		wantMsgID    uint32
+		wantData     string
+		wantCallID   uint32
+	}{
+		{name: "plain", msgID: 7, data: []byte("x"), wantDispatch: true, wantMsgID: 7, wantData: "x"},
+		{name: "call", msgID: 7 | gpack.MsgIDCallFlag, data: gpack.WithCorrelationID(9, []byte("x")),
+			wantDispatch: true, wantMsgID: 7, wantData: "x", wantCallID: 9},
+		{name: "reply without call", msgID: 7 | gpack.MsgIDReplyFlag, data: gpack.WithCorrelationID(9, []byte("x")),
+			wantDispatch: false, wantMsgID: 7 | gpack.MsgIDReplyFlag},
+		{name: "call without correlation id", msgID: 7 | gpack.MsgIDCallFlag, data: []byte{1},
+			wantDispatch: false, wantMsgID: 7 | gpack.MsgIDCallFlag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := gpack.NewMsgPackage(tt.msgID, tt.data)
+			req := NewRequest(nil, msg).(*Request)
+
+			if got := routeCall(req); got != tt.wantDispatch {
+				t.Fatalf("routeCall() = %v, want %v", got, tt.wantDispatch)
+			}
+			if msg.GetMsgID() != tt.wantMsgID {
+				t.Errorf("msgID = %d, want %d", msg.GetMsgID(), tt.wantMsgID)
+			}
+			if tt.wantDispatch && string(msg.GetData()) != tt.wantData {
+				t.Errorf("data = %q, want %q", msg.GetData(), tt.wantData)
+			}
+			if req.callID != tt.wantCallID {
+				t.Errorf("callID = %d, want %d", req.callID, tt.wantCallID)
+			}
+		})
+	}
+}
+
+type echoRouter struct {
+	BaseRouter
+	got chan uint32
+}
+
+func (r *echoRouter) Handle(request giface.IRequest) {
+	if err := request.Reply(append([]byte("re:"), request.GetData()...)); err != nil {
+		_ = request.GetConnection().SendMsg(request.GetMsgID(), []byte("not a call"))
+	}
+	if r.got != nil {
+		r.got <- request.GetMsgID()
+	}
+}
+
+// startCallTest starts a server and a connected client, the returned func stops both
+// (启动服务器和已连接的客户端，返回的函数用于停止两者)
+func startCallTest(t *testing.T, callMode bool, msgID uint32, router giface.IRouter) (giface.IClient, func()) {
+	s := NewUserConfServer(&gconf.Config{CallMode: callMode}).(*Server)
+	s.AddRouter(msgID, router)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeListener(listener)
+
+	started := make(chan struct{})
+	client := NewClient("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, WithConfigClient(&gconf.Config{CallMode: callMode}))
+	client.SetOnConnStart(func(giface.IConnection) { close(started) })
+	client.Start()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client not connected")
+	}
+	return client, func() {
+		client.Stop()
+		s.Stop()
+	}
+}
+
+func TestCall(t *testing.T) {
+	client, stop := startCallTest(t, true, 1, &echoRouter{})
+	defer stop()
+
+	reply, err := client.Call(1, []byte("ping"), 5*time.Second)
+	if err != nil {
+		t.Fatalf("Call() err = %v", err)
+	}
+	if reply.GetMsgID() != 1 || string(reply.GetData()) != "re:ping" {
+		t.Fatalf("Call() = %d %q, want 1 %q", reply.GetMsgID(), reply.GetData(), "re:ping")
+	}
+
+	if _, err = client.Call(2, nil, 100*time.Millisecond); !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("Call() to a msgID without router err = %v, want %v", err, ErrCallTimeout)
+	}
+	if _, err = client.Call(gpack.MsgIDReplyFlag, nil, time.Second); err == nil {
+		t.Fatal("Call() with a msgID overlapping the flags err = nil")
+	}
+}
+
+func TestCallModeOff(t *testing.T) {
+	// Without CallMode the highest msgIDs are routed as they are (未开启CallMode时最大的msgID也按原样路由)
+	const msgID = gpack.MsgIDCallFlag | 5
+	router := &echoRouter{got: make(chan uint32, 1)}
+	client, stop := startCallTest(t, false, msgID, router)
+	defer stop()
+
+	if _, err := client.Call(1, nil, time.Second); err == nil {
+		t.Fatal("Call() without CallMode err = nil")
+	}
+
+	if err := client.Conn().SendMsg(msgID, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-router.got:
+		if got != msgID {
+			t.Fatalf("routed msgID = %d, want %d", got, msgID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the msgID with the highest bit set was not routed")
+	}
+}
+
+func TestCallModeRejectsReservedRoutes(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("AddRouter() with a reserved msgID did not panic")
+		}
+	}()
+	s := NewUserConfServer(&gconf.Config{CallMode: true})
+	s.AddRouter(gpack.MsgIDReplyFlag, &BaseRouter{})
+}
+
+func TestBaseRequestReply(t *testing.T) {
+	var req giface.BaseRequest
+	if err := req.Reply(nil); err == nil {
+		t.Fatal("BaseRequest.Reply() err = nil")
+	}
+}
@@ -0,0 +1,140 @@
+package gnet
+
+import (
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/liyee/gray/glog"
+)
+
+// CertReloader is a reloadable certificate source for TLS listeners.
+// New handshakes get the latest loaded certificate, established connections are not affected.
+// (可热加载的TLS证书源，新的握手使用最新加载的证书，已建立的连接不受影响)
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	cert    *tls.Certificate
+	modTime time.Time // The latest modification time of the certificate and key files(证书和私钥文件的最新修改时间)
+	lock    sync.RWMutex
+}
+
+// NewCertReloader loads the certificate and private key and returns a reloadable certificate source
+// (加载证书和私钥，返回一个可热加载的证书源)
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload re-reads the certificate and private key files, the old certificate is kept if they are invalid
+// (重新读取证书和私钥文件，文件无效时保留旧证书)
+func (r *CertReloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	crt, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	r.cert = &crt
+	r.modTime = modTime
+	r.lock.Unlock()
+
+	glog.Ins().InfoF("[TLS] certificate loaded, cert: %s, key: %s", r.certFile, r.keyFile)
+	return nil
+}
+
+// GetCertificate returns the current certificate, it is used as tls.Config.GetCertificate
+// (返回当前证书，用作tls.Config.GetCertificate)
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate when one of sigs is received, or when the files are modified
+// if interval is greater than 0. It stops when done is closed.
+// (收到sigs中的信号时重新加载证书，interval大于0时轮询文件修改并重新加载，done关闭时停止)
+func (r *CertReloader) Watch(done <-chan struct{}, interval time.Duration, sigs ...os.Signal) {
+	var sigChan chan os.Signal
+	if len(sigs) > 0 {
+		sigChan = make(chan os.Signal, 1)
+		signal.Notify(sigChan, sigs...)
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		tick = ticker.C
+		defer ticker.Stop()
+	}
+
+	if sigChan == nil && tick == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-done:
+			if sigChan != nil {
+				signal.Stop(sigChan)
+			}
+			return
+		case sig := <-sigChan:
+			glog.Ins().InfoF("[TLS] reload certificate, signal = %v", sig)
+			if err := r.Reload(); err != nil {
+				glog.Ins().ErrorF("[TLS] reload certificate err: %v", err)
+			}
+		case <-tick:
+			if !r.modified() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				glog.Ins().ErrorF("[TLS] reload certificate err: %v", err)
+			}
+		}
+	}
+}
+
+// modified reports whether the files have changed since the last load
+// (自上次加载后文件是否被修改)
+func (r *CertReloader) modified() bool {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		glog.Ins().ErrorF("[TLS] stat certificate err: %v", err)
+		return false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return !modTime.Equal(r.modTime)
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -2,8 +2,10 @@ package gnet
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/liyee/gray/gconf"
@@ -13,6 +15,7 @@ import (
 	"github.com/liyee/gray/gpack"
 
 	"github.com/gorilla/websocket"
+	"github.com/xtaci/kcp-go"
 )
 
 type Client struct {
@@ -22,7 +25,10 @@ type Client struct {
 	Ip string
 	// Port of the target server to connect 目标链接服务器的端口
 	Port int
-	// Client version tcp,websocket,客户端版本 tcp,websocket
+	// Socket path of the target unix server, "@" prefix means an abstract socket on Linux
+	// 目标unix服务器的socket路径，Linux下以"@"开头表示抽象命名空间socket
+	Path string
+	// Client version tcp,websocket,unix,客户端版本 tcp,websocket,unix
 	version string
 	// Connection instance 链接实例
 	conn giface.IConnection
@@ -43,14 +49,47 @@ type Client struct {
 	hc giface.IHeartbeatChecker
 	// Use TLS 使用TLS
 	useTLS bool
+	// CA bundle used to verify the server certificate, system roots are used if it is empty
+	// 校验服务端证书的CA证书包，为空时使用系统根证书
+	tlsCAFile string
+	// Server name used for SNI and certificate verification, the host of the server address is used if it is empty
+	// 用于SNI和证书校验的服务器名称，为空时使用服务器地址
+	tlsServerName string
+	// Client certificate and private key presented to servers requiring mutual TLS
+	// 提供给双向TLS服务端的客户端证书和私钥
+	tlsCertFile string
+	tlsKeyFile  string
 	// For websocket connections
 	dialer *websocket.Dialer
-	// Error channel
+	// For KCP connections (KCP连接的参数)
+	kcpConfig *KcpConfig
+	// For TCP and websocket connections (TCP和websocket连接的socket选项)
+	tcpConfig *TcpConfig
+	// The config of the client, a copy of the global config with the worker pool turned off by default
+	// 客户端独立的配置，默认为关闭了worker工作池的全局配置副本
+	config *gconf.Config
+	// Error channel, the same channel for the lifetime of the client, it is never closed
+	// 错误管道，在客户端的整个生命周期内不变，且不会被关闭
 	ErrChan chan error
+	// The session token kept across connections, nil unless WithSessionResumeClient is used
+	// 在多个连接间保留的会话令牌，未使用WithSessionResumeClient时为nil
+	session *clientSession
+	// Reconnect with backoff when the connection closes or dialing fails, nil unless WithReconnectClient is used
+	// 连接关闭或拨号失败时按退避策略重连，未使用WithReconnectClient时为nil
+	reconnect *ReconnectConfig
+	// The number of dials and whether it is a reconnection, for the OnReconnect callback of the connection being dialed
+	// 正在拨号的连接的拨号次数及是否为重连，供OnReconnect回调使用
+	dialAttempts int
+	reconnecting bool
+	// Protects conn, exitChan and stopped (保护conn、exitChan和stopped)
+	lock    sync.Mutex
+	stopped bool
 }
 
 func NewClient(ip string, port int, opts ...ClientOption) giface.IClient {
 
+	config := newClientConfig(gconf.GlobalObject)
+
 	c := &Client{
 		// Default name, can be modified using the WithNameClient Option
 		// (默认名称，可以使用WithNameClient的Option修改)
@@ -58,23 +97,25 @@ func NewClient(ip string, port int, opts ...ClientOption) giface.IClient {
 		Ip:   ip,
 		Port: port,
 
-		msgHandler: newMsgHandler(),
-		packet:     gpack.Factory().NewPack(giface.GrayDataPack), // Default to using Zinx's TLV packet format(默认使用zinx的TLV封包方式)
-		decoder:    gdecoder.NewTLVDecoder(),                     // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
-		version:    "tcp",
-		ErrChan:    make(chan error),
+		decoder: gdecoder.NewTLVDecoder(), // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
+		version: "tcp",
+		config:  config,
+		ErrChan: make(chan error, 1),
 	}
 
 	// Apply Option settings (应用Option设置)
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.setDefaults()
 
 	return c
 }
 
 func NewWsClient(ip string, port int, opts ...ClientOption) giface.IClient {
 
+	config := newClientConfig(gconf.GlobalObject)
+
 	c := &Client{
 		// Default name, can be modified using the WithNameClient Option
 		// (默认名称，可以使用WithNameClient的Option修改)
@@ -82,18 +123,74 @@ func NewWsClient(ip string, port int, opts ...ClientOption) giface.IClient {
 		Ip:   ip,
 		Port: port,
 
-		msgHandler: newMsgHandler(),
-		packet:     gpack.Factory().NewPack(giface.GrayDataPack), // Default to using Zinx's TLV packet format(默认使用zinx的TLV封包方式)
-		decoder:    gdecoder.NewTLVDecoder(),                     // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
-		version:    "websocket",
-		dialer:     &websocket.Dialer{},
-		ErrChan:    make(chan error),
+		decoder: gdecoder.NewTLVDecoder(), // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
+		version: "websocket",
+		dialer:  &websocket.Dialer{},
+		config:  config,
+		ErrChan: make(chan error, 1),
+	}
+
+	// Apply Option settings (应用Option设置)
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.setDefaults()
+
+	return c
+}
+
+// NewUnixClient creates a client connecting to a unix domain socket server
+// (创建一个连接unix domain socket服务器的客户端)
+func NewUnixClient(path string, opts ...ClientOption) giface.IClient {
+
+	config := newClientConfig(gconf.GlobalObject)
+
+	c := &Client{
+		// Default name, can be modified using the WithNameClient Option
+		// (默认名称，可以使用WithNameClient的Option修改)
+		Name: "GrayClientUnix",
+		Path: path,
+
+		decoder: gdecoder.NewTLVDecoder(), // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
+		version: "unix",
+		config:  config,
+		ErrChan: make(chan error, 1),
+	}
+
+	// Apply Option settings (应用Option设置)
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.setDefaults()
+
+	return c
+}
+
+// NewKcpClient creates a client connecting to a KCP server, the KCP options are taken from the
+// global config by default and can be changed with WithKcpConfigClient
+// (创建一个连接KCP服务器的客户端，KCP参数默认取自全局配置，可以使用WithKcpConfigClient修改)
+func NewKcpClient(ip string, port int, opts ...ClientOption) giface.IClient {
+
+	config := newClientConfig(gconf.GlobalObject)
+
+	c := &Client{
+		// Default name, can be modified using the WithNameClient Option
+		// (默认名称，可以使用WithNameClient的Option修改)
+		Name: "GrayClientKcp",
+		Ip:   ip,
+		Port: port,
+
+		decoder: gdecoder.NewTLVDecoder(), // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
+		version: "kcp",
+		config:  config,
+		ErrChan: make(chan error, 1),
 	}
 
 	// Apply Option settings (应用Option设置)
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.setDefaults()
 
 	return c
 }
@@ -107,84 +204,246 @@ func NewTLSClient(ip string, port int, opts ...ClientOption) giface.IClient {
 	return c
 }
 
-// Start starts the client, sends requests and establishes a connection.
-// (重新启动客户端，发送请求且建立连接)
-func (c *Client) Restart() {
-	c.exitChan = make(chan struct{})
-
-	// Set worker pool size to 0 to turn off the worker pool in the client (客户端将协程池关闭)
-	gconf.GlobalObject.WorkerPoolSize = 0
+// setDefaults builds the parts derived from the config that no option has set, it runs after the options
+// so that WithConfigClient takes effect wherever it is placed
+// (根据配置构建未被Option设置的部分，在应用Option之后执行，因此WithConfigClient放在任意位置都会生效)
+func (c *Client) setDefaults() {
+	if c.msgHandler == nil {
+		c.msgHandler = newMsgHandler(c.config)
+	}
+	if c.packet == nil {
+		// Default to using Zinx's TLV packet format(默认使用zinx的TLV封包方式)
+		c.packet = gpack.Factory().NewPackWithConfig(giface.GrayDataPack, c.config)
+	}
+	switch c.version {
+	case "kcp":
+		if c.kcpConfig == nil {
+			c.kcpConfig = newKcpConfig(c.config)
+		}
+	case "tcp", "websocket":
+		if c.tcpConfig == nil {
+			c.tcpConfig = newTcpConfig(c.config)
+		}
+	}
+}
 
-	go func() {
+// applyTcpConfig sets the tcp socket options of the dialed connection, a failure is logged and the connection kept
+// (为拨号得到的连接设置tcp socket选项，失败时记录日志并保留该连接)
+func (c *Client) applyTcpConfig(conn net.Conn) {
+	if c.tcpConfig == nil {
+		return
+	}
+	if err := c.tcpConfig.apply(conn); err != nil {
+		glog.Ins().ErrorF("set tcp socket options of %s err: %v", conn.LocalAddr(), err)
+	}
+}
 
-		addr := &net.TCPAddr{
-			IP:   net.ParseIP(c.Ip),
-			Port: c.Port,
-			Zone: "", //for ipv6, ignore
+// Restart starts connecting in the background, a previous connection of the client is stopped first.
+// Dial failures are reported on ErrChan, with WithReconnectClient the client keeps reconnecting.
+// (在后台开始连接，先停止客户端之前的连接。拨号失败会发送到ErrChan，使用WithReconnectClient时客户端会持续重连)
+func (c *Client) Restart() {
+	exitChan := make(chan struct{})
+
+	c.lock.Lock()
+	prevExit, prevConn := c.exitChan, c.conn
+	c.exitChan = exitChan
+	c.stopped = false
+	c.lock.Unlock()
+
+	if prevExit != nil {
+		close(prevExit)
+		if prevConn != nil {
+			prevConn.Stop()
 		}
+	}
 
-		// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
-		switch c.version {
-		case "websocket":
-			wsAddr := fmt.Sprintf("ws://%s:%d", c.Ip, c.Port)
+	go c.run(exitChan)
+}
 
-			// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
-			wsConn, _, err := c.dialer.Dial(wsAddr, nil)
-			if err != nil {
-				// connection failed
-				glog.Ins().ErrorF("WsClient connect to server failed, err:%v", err)
-				c.ErrChan <- err
+// run dials and serves connections until exitChan is closed, it returns after the first connection or
+// dial failure unless the client reconnects
+// (拨号并服务连接直到exitChan关闭，除非开启了重连，否则在第一个连接结束或拨号失败后返回)
+func (c *Client) run(exitChan chan struct{}) {
+	failures := 0
+	reconnecting := false
+
+	for {
+		c.lock.Lock()
+		c.dialAttempts, c.reconnecting = failures+1, reconnecting
+		c.lock.Unlock()
+
+		conn, err := c.dial()
+		if err != nil {
+			c.reportErr(err)
+			failures++
+			if c.reconnect == nil {
+				return
+			}
+			if c.reconnect.MaxAttempts > 0 && failures >= c.reconnect.MaxAttempts {
+				glog.Ins().ErrorF("client give up reconnecting after %d attempts", failures)
 				return
 			}
-			// Create Connection object
-			c.conn = newWsClientConn(c, wsConn)
+			if !waitOrExit(exitChan, c.reconnect.delay(failures)) {
+				return
+			}
+			continue
+		}
 
+		c.lock.Lock()
+		select {
+		case <-exitChan:
+			// Stopped while dialing (拨号期间已被停止)
+			c.lock.Unlock()
+			closeUnstarted(conn)
+			return
 		default:
-			var conn net.Conn
-			var err error
-			if c.useTLS {
-				// TLS encryption
-				config := &tls.Config{
-					// Skip certificate verification here because the CA certificate of the certificate issuer is not authenticated
-					// (这里是跳过证书验证，因为证书签发机构的CA证书是不被认证的)
-					InsecureSkipVerify: true,
-				}
-
-				conn, err = tls.Dial("tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port), config)
-				if err != nil {
-					glog.Ins().ErrorF("tls client connect to server failed, err:%v", err)
-					c.ErrChan <- err
-					return
-				}
-			} else {
-				conn, err = net.DialTCP("tcp", nil, addr)
-				if err != nil {
-					// connection failed
-					glog.Ins().ErrorF("client connect to server failed, err:%v", err)
-					c.ErrChan <- err
-					return
-				}
-			}
-			// Create Connection object
-			c.conn = newClientConn(c, conn)
 		}
+		c.conn = conn
+		c.lock.Unlock()
 
-		glog.Ins().InfoF("[START] Zinx Client LocalAddr: %s, RemoteAddr: %s\n", c.conn.LocalAddr(), c.conn.RemoteAddr())
+		glog.Ins().InfoF("[START] Zinx Client LocalAddr: %s, RemoteAddr: %s\n", conn.LocalAddr(), conn.RemoteAddr())
 		// HeartBeat detection
 		if c.hc != nil {
-			// Bind connection and heartbeat detector after connection is successfully established
-			// (创建链接成功，绑定链接与心跳检测器)
-			c.hc.BindConn(c.conn)
+			// Bind every new connection to its own clone of the heartbeat detector
+			// (每个新连接绑定一个克隆的心跳检测器)
+			c.hc.Clone().BindConn(conn)
 		}
 
-		// Start connection
-		go c.conn.Start()
+		// Start connection, it returns once the connection is closed (启动连接，连接关闭后返回)
+		conn.Start()
 
 		select {
-		case <-c.exitChan:
+		case <-exitChan:
 			glog.Ins().InfoF("client exit.")
+			return
+		default:
+		}
+		if c.reconnect == nil {
+			return
+		}
+
+		if c.reconnect.OnDisconnect != nil {
+			c.reconnect.OnDisconnect(conn)
+		}
+		failures, reconnecting = 0, true
+		if !waitOrExit(exitChan, c.reconnect.delay(0)) {
+			return
+		}
+	}
+}
+
+// dial creates a raw socket and wraps it in a connection of the client version
+// (创建原始Socket，并包装为客户端版本对应的连接)
+func (c *Client) dial() (giface.IConnection, error) {
+	addr := &net.TCPAddr{
+		IP:   net.ParseIP(c.Ip),
+		Port: c.Port,
+		Zone: "", //for ipv6, ignore
+	}
+
+	// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
+	switch c.version {
+	case "websocket":
+		scheme := "ws"
+		if c.useTLS {
+			tlsConfig, err := c.newTLSConfig()
+			if err != nil {
+				glog.Ins().ErrorF("WsClient load tls config failed, err:%v", err)
+				return nil, err
+			}
+			c.dialer.TLSClientConfig = tlsConfig
+			scheme = "wss"
+		}
+		wsAddr := fmt.Sprintf("%s://%s:%d", scheme, c.Ip, c.Port)
+
+		// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
+		wsConn, _, err := c.dialer.Dial(wsAddr, nil)
+		if err != nil {
+			// connection failed
+			glog.Ins().ErrorF("WsClient connect to server failed, err:%v", err)
+			return nil, err
+		}
+		c.applyTcpConfig(wsConn.NetConn())
+		// Create Connection object
+		return newWsClientConn(c, wsConn), nil
+
+	case "kcp":
+		block, err := c.kcpConfig.newBlockCrypt()
+		if err != nil {
+			glog.Ins().ErrorF("KcpClient create block crypt failed, err:%v", err)
+			return nil, err
+		}
+		sess, err := kcp.DialWithOptions(fmt.Sprintf("%s:%d", c.Ip, c.Port), block, c.kcpConfig.KcpFecDataShards, c.kcpConfig.KcpFecParityShards)
+		if err != nil {
+			// connection failed
+			glog.Ins().ErrorF("KcpClient connect to server failed, err:%v", err)
+			return nil, err
+		}
+		c.kcpConfig.apply(sess)
+		// Create Connection object
+		return newKcpClientConn(c, sess), nil
+
+	case "unix":
+		conn, err := net.Dial("unix", c.Path)
+		if err != nil {
+			// connection failed
+			glog.Ins().ErrorF("UnixClient connect to server failed, err:%v", err)
+			return nil, err
+		}
+		// Create Connection object
+		return newClientConn(c, conn), nil
+
+	default:
+		var conn net.Conn
+		var err error
+		if c.useTLS {
+			// TLS encryption
+			config, err := c.newTLSConfig()
+			if err != nil {
+				glog.Ins().ErrorF("tls client load tls config failed, err:%v", err)
+				return nil, err
+			}
+			// Skip certificate verification here unless a CA bundle or server name is set by WithWssClient,
+			// because the CA certificate of the certificate issuer is not authenticated
+			// (除非通过WithWssClient设置了CA证书包或服务器名称，这里跳过证书验证，因为证书签发机构的CA证书是不被认证的)
+			if c.tlsCAFile == "" && c.tlsServerName == "" {
+				config.InsecureSkipVerify = true
+			}
+
+			conn, err = tls.Dial("tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port), config)
+			if err != nil {
+				glog.Ins().ErrorF("tls client connect to server failed, err:%v", err)
+				return nil, err
+			}
+		} else {
+			conn, err = net.DialTCP("tcp", nil, addr)
+			if err != nil {
+				// connection failed
+				glog.Ins().ErrorF("client connect to server failed, err:%v", err)
+				return nil, err
+			}
 		}
-	}()
+		c.applyTcpConfig(conn)
+		// Create Connection object
+		return newClientConn(c, conn), nil
+	}
+
+}
+
+// reportErr sends err on ErrChan without blocking, it is dropped if nobody reads ErrChan
+// (不阻塞地将err发送到ErrChan，无人读取ErrChan时丢弃)
+func (c *Client) reportErr(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.stopped {
+		return
+	}
+	select {
+	case c.ErrChan <- err:
+	default:
+		glog.Ins().DebugF("client ErrChan is full, drop err: %v", err)
+	}
 }
 
 // Start starts the client, sends requests and establishes a connection.
@@ -196,6 +455,11 @@ func (c *Client) Start() {
 		c.msgHandler.AddInterceptor(c.decoder)
 	}
 
+	// Keep the session token and resume the session on reconnection (保留会话令牌，重连时恢复会话)
+	if c.session != nil {
+		c.AddRouter(giface.SessionTokenMsgID, &sessionTokenRouter{session: c.session})
+	}
+
 	c.Restart()
 }
 
@@ -237,11 +501,24 @@ func (c *Client) StartHeartBeatWithOption(interval time.Duration, option *giface
 }
 
 func (c *Client) Stop() {
-	glog.Ins().InfoF("[STOP] Zinx Client LocalAddr: %s, RemoteAddr: %s\n", c.conn.LocalAddr(), c.conn.RemoteAddr())
-	c.conn.Stop()
-	c.exitChan <- struct{}{}
-	close(c.exitChan)
-	close(c.ErrChan)
+	c.lock.Lock()
+	if c.stopped {
+		c.lock.Unlock()
+		return
+	}
+	c.stopped = true
+	exitChan, conn := c.exitChan, c.conn
+	c.exitChan = nil
+	c.lock.Unlock()
+
+	// Close exitChan first so the connection is not reconnected (先关闭exitChan，使连接不再重连)
+	if exitChan != nil {
+		close(exitChan)
+	}
+	if conn != nil {
+		glog.Ins().InfoF("[STOP] Zinx Client LocalAddr: %s, RemoteAddr: %s\n", conn.LocalAddr(), conn.RemoteAddr())
+		conn.Stop()
+	}
 }
 
 func (c *Client) AddRouter(msgID uint32, router giface.IRouter) {
@@ -249,9 +526,31 @@ func (c *Client) AddRouter(msgID uint32, router giface.IRouter) {
 }
 
 func (c *Client) Conn() giface.IConnection {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	return c.conn
 }
 
+// Call makes a request/response call on the current connection, see giface.IConnection.Call
+// (在当前连接上发起请求/响应调用，见giface.IConnection.Call)
+func (c *Client) Call(msgID uint32, data []byte, timeout time.Duration) (giface.IMessage, error) {
+	conn := c.Conn()
+	if conn == nil {
+		return nil, errors.New("client is not connected when call")
+	}
+	return conn.Call(msgID, data, timeout)
+}
+
+// GetSessionToken returns the token of the server session, empty before it is received or without WithSessionResumeClient
+// (返回服务端会话的令牌，收到令牌之前或未使用WithSessionResumeClient时为空)
+func (c *Client) GetSessionToken() string {
+	if c.session == nil {
+		return ""
+	}
+	return c.session.getToken()
+}
+
 func (c *Client) SetOnConnStart(hookFunc func(giface.IConnection)) {
 	c.onConnStart = hookFunc
 }
@@ -280,6 +579,11 @@ func (c *Client) GetMsgHandler() giface.IMsgHandler {
 	return c.msgHandler
 }
 
+// GetConfig returns the config of the client (返回客户端的配置)
+func (c *Client) GetConfig() *gconf.Config {
+	return c.config
+}
+
 func (c *Client) AddInterceptor(interceptor giface.IInterceptor) {
 	c.msgHandler.AddInterceptor(interceptor)
 }
@@ -295,6 +599,9 @@ func (c *Client) GetLengthField() *giface.LengthField {
 }
 
 func (c *Client) GetErrChan() chan error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	return c.ErrChan
 }
 
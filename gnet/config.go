@@ -0,0 +1,29 @@
+package gnet
+
+import "github.com/liyee/gray/gconf"
+
+// configurable is implemented by the servers, clients and connections that carry their own config
+// (携带独立配置的Server、Client和连接实现该接口)
+type configurable interface {
+	GetConfig() *gconf.Config
+}
+
+// configOf returns the config carried by v, or gconf.GlobalObject if v carries none
+// (返回v携带的配置，v未携带配置时返回gconf.GlobalObject)
+func configOf(v interface{}) *gconf.Config {
+	if c, ok := v.(configurable); ok {
+		if config := c.GetConfig(); config != nil {
+			return config
+		}
+	}
+	return gconf.GlobalObject
+}
+
+// newClientConfig copies config for a client, the worker pool is turned off in the client
+// (为客户端复制一份配置，客户端关闭worker工作池)
+func newClientConfig(config *gconf.Config) *gconf.Config {
+	config = config.Clone()
+	config.WorkerPoolSize = 0
+	config.WorkerMode = ""
+	return config
+}
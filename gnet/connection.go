@@ -3,6 +3,8 @@ package gnet
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"net"
@@ -27,6 +29,7 @@ type Connection struct {
 	connIdStr  string
 	workerID   uint32
 	msgHandler giface.IMsgHandler
+	config     *gconf.Config //所属Server或Client的配置
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -35,6 +38,10 @@ type Connection struct {
 	// (有缓冲管道，用于读、写两个goroutine之间的消息通信)
 	msgBuffChan chan []byte
 
+	// Number of buffered messages that have not been written yet
+	// (发送缓冲队列中尚未写出的消息数量)
+	pendingBuffMsg int64
+
 	// Go StartWriter Flag
 	// (开始初始化写协程标志)
 	startWriterFlag int32
@@ -63,9 +70,9 @@ type Connection struct {
 	// (数据报文封包方式)
 	packet giface.IDataPack
 
-	// Last activity time
-	// (最后一次活动时间)
-	lastActivityTime time.Time
+	// Last activity time in UnixNano, accessed atomically since the admin endpoints read it
+	// (最后一次活动时间(UnixNano)，管理接口会读取，因此使用原子操作访问)
+	lastActivityTime int64
 
 	// Framedecoder for solving fragmentation and packet sticking problems
 	// (断粘包解码器)
@@ -92,6 +99,10 @@ type Connection struct {
 
 	// Close callback mutex
 	closeCallbackMutex sync.RWMutex
+
+	// Pending calls waiting for their replies
+	// (等待应答的调用)
+	calls callTable
 }
 
 // (创建一个Server服务端特性的连接的方法)
@@ -108,6 +119,8 @@ func newServerConn(server giface.IServer, conn net.Conn, connID uint64) giface.I
 		localAddr:       conn.LocalAddr().String(),
 		remoteAddr:      conn.RemoteAddr().String(),
 	}
+	// The context exists from the start so Stop works before Start (context在创建时即存在，使Start之前也可以Stop)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := server.GetLengthField()
 	if lengthField != nil {
@@ -116,9 +129,10 @@ func newServerConn(server giface.IServer, conn net.Conn, connID uint64) giface.I
 
 	// Inherited properties from server (从server继承过来的属性)
 	c.packet = server.GetPacket()
-	c.onConnStart = server.GetOnConnStart()
+	c.onConnStart = onConnStartOf(server)
 	c.onConnStop = server.GetOnConnStop()
 	c.msgHandler = server.GetMsgHandler()
+	c.config = configOf(server)
 
 	// Bind the current Connection with the Server's ConnManager
 	// (将当前的Connection与Server的ConnManager绑定)
@@ -144,6 +158,7 @@ func newClientConn(client giface.IClient, conn net.Conn) giface.IConnection {
 		localAddr:       conn.LocalAddr().String(),
 		remoteAddr:      conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := client.GetLengthField()
 	if lengthField != nil {
@@ -152,9 +167,10 @@ func newClientConn(client giface.IClient, conn net.Conn) giface.IConnection {
 
 	// Inherited properties from server (从client继承过来的属性)
 	c.packet = client.GetPacket()
-	c.onConnStart = client.GetOnConnStart()
+	c.onConnStart = onClientConnStartOf(client)
 	c.onConnStop = client.GetOnConnStop()
 	c.msgHandler = client.GetMsgHandler()
+	c.config = configOf(client)
 
 	return c
 }
@@ -168,7 +184,9 @@ func (c *Connection) StartWriter() {
 		select {
 		case data, ok := <-c.msgBuffChan:
 			if ok {
-				if err := c.Send(data); err != nil {
+				err := c.Send(data)
+				atomic.AddInt64(&c.pendingBuffMsg, -1)
+				if err != nil {
 					glog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
 					break
 				}
@@ -196,7 +214,7 @@ func (c *Connection) StartReader() {
 
 	//Reduce buffer allocation times to improve efficiency
 	// add by ray 2023-02-03
-	buffer := make([]byte, gconf.GlobalObject.IOReadBuffSize)
+	buffer := make([]byte, c.config.IOReadBuffSize)
 
 	for {
 		select {
@@ -254,7 +272,20 @@ func (c *Connection) Start() {
 			glog.Ins().ErrorF("Connection Start() error: %v", err)
 		}
 	}()
-	c.ctx, c.cancel = context.WithCancel(context.Background())
+	// Stopped before it was started, e.g. by ClearConn while the server shuts down
+	// (启动之前已被停止，例如服务关闭时被ClearConn停止)
+	if c.ctx.Err() != nil {
+		c.abort()
+		return
+	}
+
+	// Finish the TLS handshake first, so the peer certificates are available in the OnConnStart hook
+	// (先完成TLS握手，使OnConnStart钩子中可以获取对端证书)
+	if err := tlsHandshake(c.ctx, c.conn); err != nil {
+		glog.Ins().ErrorF("tls handshake with %s err: %v", c.remoteAddr, err)
+		c.abort()
+		return
+	}
 
 	// Execute the hook method for processing business logic when creating a connection
 	// (按照用户传递进来的创建连接时需要处理的业务，执行钩子方法)
@@ -289,6 +320,26 @@ func (c *Connection) Stop() {
 	c.cancel()
 }
 
+// abort closes a connection that is not going to be started (关闭不会再启动的连接)
+func (c *Connection) abort() {
+	c.cancel()
+	_ = c.conn.Close()
+	if c.connManager != nil {
+		c.connManager.Remove(c)
+	}
+
+	// Close callbacks may have been added before Start, e.g. by joining a group (启动前可能已添加关闭回调，例如加入分组)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				glog.Ins().ErrorF("Conn abort panic: %v", err)
+			}
+		}()
+
+		c.InvokeCloseCallbacks()
+	}()
+}
+
 func (c *Connection) GetConnection() net.Conn {
 	return c.conn
 }
@@ -322,6 +373,14 @@ func (c *Connection) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
 }
 
+func (c *Connection) GetPeerCertificates() []*x509.Certificate {
+	return verifiedPeerCertificates(c.conn)
+}
+
+func (c *Connection) GetTLSConnectionState() (tls.ConnectionState, bool) {
+	return tlsConnectionState(c.conn)
+}
+
 func (c *Connection) Flush() error {
 	if c.isClosed() == true {
 		return errors.New("connection closed when flush data")
@@ -344,9 +403,21 @@ func (c *Connection) Send(data []byte) error {
 }
 
 func (c *Connection) SendToQueue(data []byte) error {
+	return c.sendToQueue(data, true)
+}
+
+// trySendToQueue puts data into the send queue without waiting, it fails at once if the queue is full
+// (不等待地将data放入发送队列，队列已满时立即失败)
+func (c *Connection) trySendToQueue(data []byte) error {
+	return c.sendToQueue(data, false)
+}
+
+// sendToQueue puts data into the send queue, waiting for a free slot for a while if wait is set
+// (将data放入发送队列，设置wait时会等待一段时间直到有空位)
+func (c *Connection) sendToQueue(data []byte, wait bool) error {
 
 	if c.msgBuffChan == nil && c.setStartWriterFlag() {
-		c.msgBuffChan = make(chan []byte, gconf.GlobalObject.MaxMsgChanLen)
+		c.msgBuffChan = make(chan []byte, c.config.MaxMsgChanLen)
 		// Start a Goroutine to write data back to the client
 		// This method only reads data from the MsgBuffChan without allocating memory or starting a Goroutine
 		// (开启用于写回客户端数据流程的Goroutine
@@ -354,9 +425,6 @@ func (c *Connection) SendToQueue(data []byte) error {
 		go c.StartWriter()
 	}
 
-	idleTimeout := time.NewTimer(5 * time.Millisecond)
-	defer idleTimeout.Stop()
-
 	if c.isClosed() == true {
 		return errors.New("Connection closed when send buff msg")
 	}
@@ -366,13 +434,33 @@ func (c *Connection) SendToQueue(data []byte) error {
 		return errors.New("Pack data is nil")
 	}
 
+	// Count the message before queuing it, the writer may dequeue it before the send returns
+	// (入队前先计数，因为发送返回前写协程可能已经取出该消息)
+	atomic.AddInt64(&c.pendingBuffMsg, 1)
+	if !wait {
+		select {
+		case c.msgBuffChan <- data:
+			return nil
+		default:
+			atomic.AddInt64(&c.pendingBuffMsg, -1)
+			metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
+			return errSendQueueFull
+		}
+	}
+
+	idleTimeout := time.NewTimer(5 * time.Millisecond)
+	defer idleTimeout.Stop()
+
 	// Send timeout
 	select {
 	case <-c.ctx.Done():
+		atomic.AddInt64(&c.pendingBuffMsg, -1)
 		// Close all channels associated with the connection
 		close(c.msgBuffChan)
 		return errors.New("connection closed when send buff msg")
 	case <-idleTimeout.C:
+		atomic.AddInt64(&c.pendingBuffMsg, -1)
+		metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
 		return errors.New("send buff msg timeout")
 	case c.msgBuffChan <- data:
 		return nil
@@ -398,6 +486,7 @@ func (c *Connection) SendMsg(msgID uint32, data []byte) error {
 		glog.Ins().ErrorF("SendMsg err msg ID = %d, data = %+v, err = %+v", msgID, string(msg), err)
 		return err
 	}
+	metricsOf(c.msgHandler).msgSent(msgID, len(data))
 
 	return nil
 }
@@ -408,8 +497,21 @@ func (c *Connection) SendBuffMsg(msgID uint32, data []byte) error {
 		glog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
 	}
-	return c.SendToQueue(msg)
+	if err = c.SendToQueue(msg); err != nil {
+		return err
+	}
+	metricsOf(c.msgHandler).msgSent(msgID, len(data))
+	return nil
+}
 
+// Call sends data to msgID of the peer and waits for its reply, see giface.IConnection.Call
+// (向对端的msgID发送data并等待应答，见giface.IConnection.Call)
+func (c *Connection) Call(msgID uint32, data []byte, timeout time.Duration) (giface.IMessage, error) {
+	return c.calls.call(c, msgID, data, timeout)
+}
+
+func (c *Connection) resolveCall(corrID uint32, msg giface.IMessage) bool {
+	return c.calls.resolve(corrID, msg)
 }
 
 func (c *Connection) SetProperty(key string, value interface{}) {
@@ -440,6 +542,33 @@ func (c *Connection) RemoveProperty(key string) {
 	delete(c.property, key)
 }
 
+// GetProperties returns a copy of all the connection properties (返回所有链接属性的副本)
+func (c *Connection) GetProperties() map[string]interface{} {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+
+	properties := make(map[string]interface{}, len(c.property))
+	for k, v := range c.property {
+		properties[k] = v
+	}
+	return properties
+}
+
+// LastActivity returns the last time data was received from the peer (返回最后一次收到对端数据的时间)
+func (c *Connection) LastActivity() time.Time {
+	nano := atomic.LoadInt64(&c.lastActivityTime)
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// SendQueueLen returns the number of buffered messages not written yet and the capacity of the send queue
+// (返回发送缓冲队列中尚未写出的消息数量及队列容量)
+func (c *Connection) SendQueueLen() (int, int) {
+	return int(atomic.LoadInt64(&c.pendingBuffMsg)), int(c.config.MaxMsgChanLen)
+}
+
 func (c *Connection) Context() context.Context {
 	return c.ctx
 }
@@ -496,11 +625,11 @@ func (c *Connection) IsAlive() bool {
 	// Check the last activity time of the connection. If it's beyond the heartbeat interval,
 	// then the connection is considered dead.
 	// (检查连接最后一次活动时间，如果超过心跳间隔，则认为连接已经死亡)
-	return time.Now().Sub(c.lastActivityTime) < gconf.GlobalObject.HeartbeatMaxDuration()
+	return time.Since(c.LastActivity()) < c.config.HeartbeatMaxDuration()
 }
 
 func (c *Connection) updateActivity() {
-	c.lastActivityTime = time.Now()
+	atomic.StoreInt64(&c.lastActivityTime, time.Now().UnixNano())
 }
 
 func (c *Connection) SetHeartBeat(checker giface.IHeartbeatChecker) {
@@ -523,6 +652,15 @@ func (c *Connection) GetMsgHandler() giface.IMsgHandler {
 	return c.msgHandler
 }
 
+func (c *Connection) getPacket() giface.IDataPack {
+	return c.packet
+}
+
+// GetConfig returns the config inherited from the Server or Client (返回从Server或Client继承的配置)
+func (c *Connection) GetConfig() *gconf.Config {
+	return c.config
+}
+
 func (c *Connection) isClosed() bool {
 	return c.ctx == nil || c.ctx.Err() != nil
 }
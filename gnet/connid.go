@@ -0,0 +1,86 @@
+package gnet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+
+	// SnowflakeMaxNodeID is the largest node ID of the snowflake generator (雪花算法生成器的最大节点ID)
+	SnowflakeMaxNodeID = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq    = 1<<snowflakeSeqBits - 1
+)
+
+// snowflakeEpoch is the time the snowflake timestamps count from, 2024-01-01 00:00:00 UTC in milliseconds
+// (雪花算法时间戳的起始时间，2024-01-01 00:00:00 UTC，单位毫秒)
+const snowflakeEpoch int64 = 1704067200000
+
+// SnowflakeConnIDGenerator generates snowflake style connection IDs:
+// 41 bits of milliseconds since 2024-01-01, 10 bits of node ID and 12 bits of sequence.
+// IDs of different nodes never collide, the timestamps never run ahead of the clock so IDs of one node keep
+// increasing across restarts unless the clock of the new process is behind the old one.
+// (雪花算法连接ID生成器：41位自2024-01-01起的毫秒数，10位节点ID，12位序列号。
+// 不同节点的ID不会冲突，时间戳不会超前于时钟，因此除非新进程的时钟落后于旧进程，同一节点重启后ID仍然递增)
+type SnowflakeConnIDGenerator struct {
+	lock     sync.Mutex
+	nodeID   uint64
+	lastTime int64
+	seq      uint64
+	now      func() time.Time
+}
+
+// NewSnowflakeConnIDGenerator creates a snowflake generator, nodeID must be unique among the nodes and between 0 and SnowflakeMaxNodeID
+// (创建雪花算法生成器，nodeID在各节点间必须唯一，取值范围0到SnowflakeMaxNodeID)
+func NewSnowflakeConnIDGenerator(nodeID int) (*SnowflakeConnIDGenerator, error) {
+	if nodeID < 0 || nodeID > SnowflakeMaxNodeID {
+		return nil, fmt.Errorf("snowflake node id %d out of range [0, %d]", nodeID, SnowflakeMaxNodeID)
+	}
+	return &SnowflakeConnIDGenerator{nodeID: uint64(nodeID), now: time.Now}, nil
+}
+
+// NextID returns the next connection ID. When the sequence of a millisecond is exhausted or the clock
+// goes backwards it blocks until the clock passes the last timestamp used
+// (返回下一个连接ID。当某毫秒的序列号用尽或时钟回拨时，阻塞直到时钟超过上次使用的时间戳)
+func (g *SnowflakeConnIDGenerator) NextID() uint64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := g.millis()
+	switch {
+	case now > g.lastTime:
+		g.seq = 0
+	case now == g.lastTime && g.seq < snowflakeMaxSeq:
+		g.seq++
+	default:
+		now = g.waitAfter(g.lastTime)
+		g.seq = 0
+	}
+	g.lastTime = now
+
+	return uint64(g.lastTime)<<(snowflakeNodeBits+snowflakeSeqBits) | g.nodeID<<snowflakeSeqBits | g.seq
+}
+
+// millis returns the milliseconds since snowflakeEpoch (返回自snowflakeEpoch起的毫秒数)
+func (g *SnowflakeConnIDGenerator) millis() int64 {
+	return g.now().UnixMilli() - snowflakeEpoch
+}
+
+// waitAfter sleeps until the clock is past last and returns the time then (睡眠直到时钟超过last，返回此时的时间)
+func (g *SnowflakeConnIDGenerator) waitAfter(last int64) int64 {
+	for {
+		now := g.millis()
+		if now > last {
+			return now
+		}
+		time.Sleep(time.Duration(last-now+1) * time.Millisecond)
+	}
+}
+
+// NodeID returns the node ID of the generator (返回生成器的节点ID)
+func (g *SnowflakeConnIDGenerator) NodeID() int {
+	return int(g.nodeID)
+}
@@ -0,0 +1,112 @@
+package gnet
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedClock returns the milliseconds of script one by one, the last one repeats
+// (依次返回script中的毫秒数，最后一个值重复返回)
+type scriptedClock struct {
+	lock   sync.Mutex
+	script []int64
+	last   int64 // the latest time returned (最近一次返回的时间)
+}
+
+func (c *scriptedClock) now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.last = c.script[0]
+	if len(c.script) > 1 {
+		c.script = c.script[1:]
+	}
+	return time.UnixMilli(c.last + snowflakeEpoch)
+}
+
+func repeatMillis(ms int64, n int) []int64 {
+	script := make([]int64, n)
+	for i := range script {
+		script[i] = ms
+	}
+	return script
+}
+
+func TestSnowflakeNextID(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []int64
+		ids    int
+	}{
+		{name: "increasing clock", script: []int64{100, 101, 102}, ids: 3},
+		// The sequence of millisecond 100 is exhausted by the 4097th ID (第4097个ID用尽毫秒100的序列号)
+		{name: "sequence rollover", script: append(repeatMillis(100, snowflakeMaxSeq+3), 101), ids: snowflakeMaxSeq + 3},
+		{name: "clock rollback", script: []int64{100, 90, 95, 100, 101}, ids: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewSnowflakeConnIDGenerator(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := &scriptedClock{script: tt.script}
+			g.now = clock.now
+
+			var prev uint64
+			for i := 0; i < tt.ids; i++ {
+				id := g.NextID()
+				if i > 0 && id <= prev {
+					t.Fatalf("ID %d = %d, not greater than %d", i, id, prev)
+				}
+				prev = id
+
+				if node := id >> snowflakeSeqBits & SnowflakeMaxNodeID; node != 7 {
+					t.Fatalf("ID %d node = %d, want 7", i, node)
+				}
+				// The timestamp never runs ahead of the clock (时间戳从不超前于时钟)
+				if ms := int64(id >> (snowflakeNodeBits + snowflakeSeqBits)); ms > clock.last {
+					t.Fatalf("ID %d timestamp = %d, ahead of the clock %d", i, ms, clock.last)
+				}
+			}
+		})
+	}
+}
+
+func TestSnowflakeNextIDConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		perRoutine = 2000
+	)
+	g, err := NewSnowflakeConnIDGenerator(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make([][]uint64, goroutines)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perRoutine; j++ {
+				results[i] = append(results[i], g.NextID())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]struct{}, goroutines*perRoutine)
+	for _, ids := range results {
+		for j, id := range ids {
+			if _, ok := seen[id]; ok {
+				t.Fatalf("duplicate ID %d", id)
+			}
+			seen[id] = struct{}{}
+			if j > 0 && id <= ids[j-1] {
+				t.Fatalf("ID %d after %d in one goroutine", id, ids[j-1])
+			}
+		}
+	}
+}
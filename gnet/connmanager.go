@@ -2,8 +2,11 @@ package gnet
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"sync"
 
+	"github.com/liyee/gray/gconf"
 	"github.com/liyee/gray/giface"
 	"github.com/liyee/gray/glog"
 	"github.com/liyee/gray/gutils"
@@ -11,11 +14,24 @@ import (
 
 type ConnManager struct {
 	connections gutils.ShardLockMaps
+
+	indexPolicy string                            // the policy of duplicate index keys (索引键重复时的处理策略)
+	indexLock   sync.RWMutex                      // protects indexes and bound (保护indexes和bound)
+	indexes     map[indexKey][]giface.IConnection // the connections bound to each index key in bind order (按绑定顺序保存每个索引键绑定的连接)
+	bound       map[string]map[indexKey]struct{}  // the index keys bound to each connection (每个连接绑定的索引键)
+}
+
+type indexKey struct {
+	index string
+	key   string
 }
 
-func newConnManager() *ConnManager {
+func newConnManager(indexPolicy string) *ConnManager {
 	return &ConnManager{
 		connections: gutils.NewShardLockMaps(),
+		indexPolicy: indexPolicy,
+		indexes:     make(map[indexKey][]giface.IConnection),
+		bound:       make(map[string]map[indexKey]struct{}),
 	}
 }
 
@@ -29,6 +45,7 @@ func (connMgr *ConnManager) Add(conn giface.IConnection) {
 func (connMgr *ConnManager) Remove(conn giface.IConnection) {
 
 	connMgr.connections.Remove(conn.GetConnIdStr()) // 删除连接信息
+	connMgr.unbindAll(conn)                         // 解除连接绑定的全部索引
 
 	glog.Ins().DebugF("connection Remove ConnID=%d successfully: conn num = %d", conn.GetConnID(), connMgr.Len())
 }
@@ -123,3 +140,112 @@ func (connMgr *ConnManager) Range2(cb func(string, giface.IConnection, interface
 
 	return err
 }
+
+func (connMgr *ConnManager) BindIndex(index string, key string, conn giface.IConnection) error {
+	k := indexKey{index: index, key: key}
+	var kicked []giface.IConnection
+
+	connMgr.indexLock.Lock()
+
+	// A connection removed already would never be unbound (已经移除的连接不会再被解绑)
+	if _, ok := connMgr.connections.Get(conn.GetConnIdStr()); !ok {
+		connMgr.indexLock.Unlock()
+		return errors.New("connection not found")
+	}
+
+	conns := connMgr.indexes[k]
+	for _, c := range conns {
+		if c == conn {
+			connMgr.indexLock.Unlock()
+			return nil
+		}
+	}
+
+	if len(conns) > 0 {
+		switch connMgr.indexPolicy {
+		case gconf.ConnIndexRejectNew:
+			connMgr.indexLock.Unlock()
+			return fmt.Errorf("index %s key %s is already bound to ConnID=%d", index, key, conns[0].GetConnID())
+		case gconf.ConnIndexAllow:
+		default:
+			// The old connections are unbound at once so lookups find the new one, and stopped after unlocking
+			// (旧连接立即解绑使查找得到新连接，解锁后再停止旧连接)
+			kicked = conns
+			for _, c := range kicked {
+				connMgr.unbindLocked(k, c)
+			}
+		}
+	}
+
+	connMgr.indexes[k] = append(connMgr.indexes[k], conn)
+	if connMgr.bound[conn.GetConnIdStr()] == nil {
+		connMgr.bound[conn.GetConnIdStr()] = make(map[indexKey]struct{})
+	}
+	connMgr.bound[conn.GetConnIdStr()][k] = struct{}{}
+
+	connMgr.indexLock.Unlock()
+
+	for _, c := range kicked {
+		glog.Ins().InfoF("index %s key %s is bound to ConnID=%d, kick ConnID=%d", index, key, conn.GetConnID(), c.GetConnID())
+		c.Stop()
+	}
+	return nil
+}
+
+func (connMgr *ConnManager) UnbindIndex(index string, key string, conn giface.IConnection) {
+	connMgr.indexLock.Lock()
+	connMgr.unbindLocked(indexKey{index: index, key: key}, conn)
+	connMgr.indexLock.Unlock()
+}
+
+func (connMgr *ConnManager) GetByIndex(index string, key string) (giface.IConnection, error) {
+	connMgr.indexLock.RLock()
+	defer connMgr.indexLock.RUnlock()
+
+	if conns := connMgr.indexes[indexKey{index: index, key: key}]; len(conns) > 0 {
+		return conns[len(conns)-1], nil
+	}
+
+	return nil, errors.New("connection not found")
+}
+
+func (connMgr *ConnManager) GetAllByIndex(index string, key string) []giface.IConnection {
+	connMgr.indexLock.RLock()
+	defer connMgr.indexLock.RUnlock()
+
+	conns := connMgr.indexes[indexKey{index: index, key: key}]
+	return append(make([]giface.IConnection, 0, len(conns)), conns...)
+}
+
+// unbindAll removes all the index keys bound to conn (解除连接绑定的全部索引键)
+func (connMgr *ConnManager) unbindAll(conn giface.IConnection) {
+	connMgr.indexLock.Lock()
+	defer connMgr.indexLock.Unlock()
+
+	for k := range connMgr.bound[conn.GetConnIdStr()] {
+		connMgr.unbindLocked(k, conn)
+	}
+}
+
+// unbindLocked removes the binding of k and conn, indexLock must be held (解除k与conn的绑定，调用方需持有indexLock)
+func (connMgr *ConnManager) unbindLocked(k indexKey, conn giface.IConnection) {
+	conns := connMgr.indexes[k]
+	for i, c := range conns {
+		if c == conn {
+			conns = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(connMgr.indexes, k)
+	} else {
+		connMgr.indexes[k] = conns
+	}
+
+	if keys, ok := connMgr.bound[conn.GetConnIdStr()]; ok {
+		delete(keys, k)
+		if len(keys) == 0 {
+			delete(connMgr.bound, conn.GetConnIdStr())
+		}
+	}
+}
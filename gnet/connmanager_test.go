@@ -0,0 +1,112 @@
+package gnet
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+)
+
+// fakeConn implements the methods of IConnection used by the ConnManager (实现ConnManager用到的IConnection方法)
+type fakeConn struct {
+	giface.IConnection
+	id      uint64
+	stopped bool
+}
+
+func (c *fakeConn) GetConnID() uint64    { return c.id }
+func (c *fakeConn) GetConnIdStr() string { return strconv.FormatUint(c.id, 10) }
+func (c *fakeConn) Stop()                { c.stopped = true }
+
+func TestConnManagerIndexPolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantErr     bool
+		wantGet     uint64   // the connection returned by GetByIndex (GetByIndex返回的连接)
+		wantAll     []uint64 // the connections returned by GetAllByIndex (GetAllByIndex返回的连接)
+		wantStopped bool     // the old connection is stopped (旧连接被停止)
+	}{
+		{policy: "", wantGet: 2, wantAll: []uint64{2}, wantStopped: true},
+		{policy: gconf.ConnIndexKickOld, wantGet: 2, wantAll: []uint64{2}, wantStopped: true},
+		{policy: gconf.ConnIndexRejectNew, wantErr: true, wantGet: 1, wantAll: []uint64{1}},
+		{policy: gconf.ConnIndexAllow, wantGet: 2, wantAll: []uint64{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			connMgr := newConnManager(tt.policy)
+			oldConn, newConn := &fakeConn{id: 1}, &fakeConn{id: 2}
+			connMgr.Add(oldConn)
+			connMgr.Add(newConn)
+
+			if err := connMgr.BindIndex("user", "u1", oldConn); err != nil {
+				t.Fatalf("BindIndex(old) err = %v", err)
+			}
+			if err := connMgr.BindIndex("user", "u1", oldConn); err != nil {
+				t.Fatalf("BindIndex(old) again err = %v", err)
+			}
+			if err := connMgr.BindIndex("user", "u1", newConn); (err != nil) != tt.wantErr {
+				t.Fatalf("BindIndex(new) err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, err := connMgr.GetByIndex("user", "u1")
+			if err != nil || got.GetConnID() != tt.wantGet {
+				t.Fatalf("GetByIndex() = %v, %v, want ConnID=%d", got, err, tt.wantGet)
+			}
+			all := connMgr.GetAllByIndex("user", "u1")
+			if len(all) != len(tt.wantAll) {
+				t.Fatalf("GetAllByIndex() len = %d, want %d", len(all), len(tt.wantAll))
+			}
+			for i, c := range all {
+				if c.GetConnID() != tt.wantAll[i] {
+					t.Errorf("GetAllByIndex()[%d] = ConnID=%d, want %d", i, c.GetConnID(), tt.wantAll[i])
+				}
+			}
+			if oldConn.stopped != tt.wantStopped {
+				t.Errorf("old connection stopped = %v, want %v", oldConn.stopped, tt.wantStopped)
+			}
+			if newConn.stopped {
+				t.Error("new connection stopped")
+			}
+		})
+	}
+}
+
+func TestConnManagerUnbind(t *testing.T) {
+	tests := []struct {
+		name   string
+		unbind func(connMgr *ConnManager, conn giface.IConnection)
+	}{
+		{name: "unbind index", unbind: func(connMgr *ConnManager, conn giface.IConnection) { connMgr.UnbindIndex("user", "u1", conn) }},
+		{name: "remove connection", unbind: func(connMgr *ConnManager, conn giface.IConnection) { connMgr.Remove(conn) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connMgr := newConnManager(gconf.ConnIndexAllow)
+			conn := &fakeConn{id: 1}
+			connMgr.Add(conn)
+			if err := connMgr.BindIndex("user", "u1", conn); err != nil {
+				t.Fatal(err)
+			}
+			if err := connMgr.BindIndex("room", "r1", conn); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.unbind(connMgr, conn)
+
+			if _, err := connMgr.GetByIndex("user", "u1"); err == nil {
+				t.Error("GetByIndex() finds the unbound connection")
+			}
+		})
+	}
+
+	t.Run("removed connection", func(t *testing.T) {
+		connMgr := newConnManager(gconf.ConnIndexKickOld)
+		conn := &fakeConn{id: 1}
+		if err := connMgr.BindIndex("user", "u1", conn); err == nil {
+			t.Fatal("BindIndex() of a connection not in the manager err = nil")
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package gnet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/glog"
+	"github.com/liyee/gray/gpack"
+)
+
+// errSendQueueFull is returned when a message is put into a full send queue without waiting
+// (不等待地向已满的发送队列放入消息时返回)
+var errSendQueueFull = errors.New("send buff msg queue is full")
+
+// packetHolder is implemented by the connections, it exposes the data pack so a broadcast is packed only once,
+// and puts the packed message into the send queue without waiting so a slow member does not stall the others
+// (连接实现该接口以暴露封包方式，使广播只需封包一次，并不等待地将消息放入发送队列，使慢成员不会拖慢其他成员)
+type packetHolder interface {
+	getPacket() giface.IDataPack
+	trySendToQueue(data []byte) error
+}
+
+type GroupManager struct {
+	lock   sync.RWMutex
+	groups map[string]map[uint64]giface.IConnection // the members of each group (每个分组的成员)
+	joined map[uint64]map[string]struct{}           // the groups joined by each connection (每个连接加入的分组)
+}
+
+func newGroupManager() *GroupManager {
+	return &GroupManager{
+		groups: make(map[string]map[uint64]giface.IConnection),
+		joined: make(map[uint64]map[string]struct{}),
+	}
+}
+
+func (gm *GroupManager) Join(group string, conn giface.IConnection) error {
+	connID := conn.GetConnID()
+
+	gm.lock.Lock()
+	members, ok := gm.groups[group]
+	if !ok {
+		members = make(map[uint64]giface.IConnection)
+		gm.groups[group] = members
+	}
+	if _, ok = members[connID]; ok {
+		gm.lock.Unlock()
+		return nil
+	}
+	members[connID] = conn
+	if gm.joined[connID] == nil {
+		gm.joined[connID] = make(map[string]struct{})
+	}
+	gm.joined[connID][group] = struct{}{}
+	gm.lock.Unlock()
+
+	// The callback is added before checking whether conn is closed, so either it runs or the check sees the close
+	// (先添加回调再检查连接是否已关闭，因此要么回调会执行，要么检查能发现连接已关闭)
+	conn.AddCloseCallback(gm, group, func() {
+		gm.leave(group, conn)
+	})
+	if ctx := conn.Context(); ctx != nil && ctx.Err() != nil {
+		gm.Leave(group, conn)
+		return errors.New("connection closed when join group")
+	}
+
+	glog.Ins().DebugF("ConnID=%d join group %s successfully: member num = %d", connID, group, gm.Count(group))
+	return nil
+}
+
+func (gm *GroupManager) Leave(group string, conn giface.IConnection) {
+	if gm.leave(group, conn) {
+		conn.RemoveCloseCallback(gm, group)
+	}
+}
+
+// leave removes conn from group without touching its close callbacks, which may be running
+// (将连接移出分组，不修改其关闭回调，因为回调可能正在执行)
+func (gm *GroupManager) leave(group string, conn giface.IConnection) bool {
+	connID := conn.GetConnID()
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	members, ok := gm.groups[group]
+	if !ok {
+		return false
+	}
+	// Another connection may have reused the ID, only the joined one is removed
+	// (连接ID可能被其他连接复用，只移除加入分组的那个连接)
+	if member, ok := members[connID]; !ok || member != conn {
+		return false
+	}
+
+	delete(members, connID)
+	if len(members) == 0 {
+		delete(gm.groups, group)
+	}
+	delete(gm.joined[connID], group)
+	if len(gm.joined[connID]) == 0 {
+		delete(gm.joined, connID)
+	}
+	return true
+}
+
+func (gm *GroupManager) LeaveAll(conn giface.IConnection) {
+	for _, group := range gm.Groups(conn) {
+		gm.Leave(group, conn)
+	}
+}
+
+func (gm *GroupManager) Members(group string) []giface.IConnection {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	members := gm.groups[group]
+	conns := make([]giface.IConnection, 0, len(members))
+	for _, conn := range members {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (gm *GroupManager) Count(group string) int {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	return len(gm.groups[group])
+}
+
+func (gm *GroupManager) Groups(conn giface.IConnection) []string {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	joined := gm.joined[conn.GetConnID()]
+	groups := make([]string, 0, len(joined))
+	for group := range joined {
+		if gm.groups[group][conn.GetConnID()] == conn {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func (gm *GroupManager) Broadcast(group string, msgID uint32, data []byte, exclude ...uint64) ([]uint64, error) {
+	var excluded map[uint64]struct{}
+	if len(exclude) > 0 {
+		excluded = make(map[uint64]struct{}, len(exclude))
+		for _, connID := range exclude {
+			excluded[connID] = struct{}{}
+		}
+	}
+
+	// Connections normally share the data pack of their server, so the message is packed once per data pack
+	// (连接通常共用所属服务器的封包方式，因此每种封包方式只封包一次)
+	packed := make(map[giface.IDataPack][]byte, 1)
+
+	var failed []uint64
+	var errs []error
+	for _, conn := range gm.Members(group) {
+		if _, ok := excluded[conn.GetConnID()]; ok {
+			continue
+		}
+		if err := sendPacked(conn, msgID, data, packed); err != nil {
+			failed = append(failed, conn.GetConnID())
+			errs = append(errs, fmt.Errorf("connID=%d: %w", conn.GetConnID(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		glog.Ins().ErrorF("broadcast msgID = %d to group %s failed on %d connections", msgID, group, len(errs))
+	}
+	return failed, errors.Join(errs...)
+}
+
+// sendPacked puts the message into the send queue of conn without waiting, reusing the packed bytes of the same
+// data pack. Connections of other implementations are sent with SendBuffMsg.
+// (不等待地将消息放入连接的发送队列，相同封包方式复用已封包的数据。其他实现的连接使用SendBuffMsg发送)
+func sendPacked(conn giface.IConnection, msgID uint32, data []byte, packed map[giface.IDataPack][]byte) error {
+	holder, ok := conn.(packetHolder)
+	if !ok {
+		return conn.SendBuffMsg(msgID, data)
+	}
+
+	packet := holder.getPacket()
+	msg, ok := packed[packet]
+	if !ok {
+		var err error
+		msg, err = packet.Pack(gpack.NewMsgPackage(msgID, data))
+		if err != nil {
+			glog.Ins().ErrorF("Pack error msg ID = %d", msgID)
+			return errors.New("Pack error msg ")
+		}
+		packed[packet] = msg
+	}
+
+	if err := holder.trySendToQueue(msg); err != nil {
+		return err
+	}
+	metricsOf(conn.GetMsgHandler()).msgSent(msgID, len(data))
+	return nil
+}
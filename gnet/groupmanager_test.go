@@ -0,0 +1,180 @@
+package gnet
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/gpack"
+)
+
+// countingPack counts the messages packed (统计封包次数)
+type countingPack struct {
+	giface.IDataPack
+	packs int32
+}
+
+func (p *countingPack) Pack(msg giface.IMessage) ([]byte, error) {
+	atomic.AddInt32(&p.packs, 1)
+	return p.IDataPack.Pack(msg)
+}
+
+// groupTestConn is a group member whose send queue is a channel (发送队列为管道的分组成员)
+type groupTestConn struct {
+	giface.IConnection
+	id     uint64
+	packet giface.IDataPack
+	queue  chan []byte
+}
+
+func (c *groupTestConn) GetConnID() uint64                                   { return c.id }
+func (c *groupTestConn) GetMsgHandler() giface.IMsgHandler                   { return nil }
+func (c *groupTestConn) Context() context.Context                            { return context.Background() }
+func (c *groupTestConn) AddCloseCallback(handler, key interface{}, f func()) {}
+func (c *groupTestConn) RemoveCloseCallback(handler, key interface{})        {}
+func (c *groupTestConn) getPacket() giface.IDataPack                         { return c.packet }
+
+func (c *groupTestConn) trySendToQueue(data []byte) error {
+	select {
+	case c.queue <- data:
+		return nil
+	default:
+		return errSendQueueFull
+	}
+}
+
+func TestGroupBroadcast(t *testing.T) {
+	tests := []struct {
+		name       string
+		exclude    []uint64
+		full       []uint64 // the members whose send queue is full (发送队列已满的成员)
+		wantSent   []uint64
+		wantFailed []uint64
+	}{
+		{name: "all", wantSent: []uint64{1, 2, 3, 4}},
+		{name: "exclude", exclude: []uint64{2, 3}, wantSent: []uint64{1, 4}},
+		{name: "full queue", full: []uint64{3}, wantSent: []uint64{1, 2, 4}, wantFailed: []uint64{3}},
+		{name: "full and excluded", exclude: []uint64{3}, full: []uint64{3, 4}, wantSent: []uint64{1, 2}, wantFailed: []uint64{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gm := newGroupManager()
+			packet := &countingPack{IDataPack: gpack.NewDataPack()}
+			conns := make(map[uint64]*groupTestConn)
+			for id := uint64(1); id <= 4; id++ {
+				conns[id] = &groupTestConn{id: id, packet: packet, queue: make(chan []byte, 1)}
+			}
+			for _, id := range tt.full {
+				conns[id].queue <- []byte("queued")
+			}
+			for _, conn := range conns {
+				if err := gm.Join("room", conn); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			failed, err := gm.Broadcast("room", 1, []byte("hi"), tt.exclude...)
+			if (err != nil) != (len(tt.wantFailed) > 0) {
+				t.Fatalf("Broadcast() err = %v", err)
+			}
+			sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+			if !equalIDs(failed, tt.wantFailed) {
+				t.Fatalf("Broadcast() failed = %v, want %v", failed, tt.wantFailed)
+			}
+			if packs := atomic.LoadInt32(&packet.packs); packs != 1 {
+				t.Fatalf("packed %d times, want 1", packs)
+			}
+
+			var sent []uint64
+			for id := uint64(1); id <= 4; id++ {
+				select {
+				case msg := <-conns[id].queue:
+					if string(msg) != "queued" {
+						sent = append(sent, id)
+					}
+				default:
+				}
+			}
+			if !equalIDs(sent, tt.wantSent) {
+				t.Fatalf("sent to %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGroupBroadcastDoesNotWait(t *testing.T) {
+	s := NewUserConfServer(&gconf.Config{MaxMsgChanLen: 1}).(*Server)
+	// Nobody reads the peer, so the writer blocks and the send queue fills up (对端无人读取，写协程阻塞，发送队列被填满)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := newServerConn(s, local, 1)
+	go conn.Start()
+	defer conn.Stop()
+
+	if err := s.GroupMgr.Join("room", conn); err != nil {
+		t.Fatal(err)
+	}
+
+	const broadcasts = 100
+	failures := 0
+	start := time.Now()
+	for i := 0; i < broadcasts; i++ {
+		if failed, _ := s.GroupMgr.Broadcast("room", 1, []byte("hi")); len(failed) > 0 {
+			failures++
+		}
+	}
+	if failures == 0 {
+		t.Fatal("no broadcast failed on a full send queue")
+	}
+	// A waiting send would take 5ms for each failure (等待的发送每次失败需要5ms)
+	if elapsed := time.Since(start); elapsed > time.Duration(failures)*5*time.Millisecond/2 {
+		t.Fatalf("%d broadcasts with %d failures took %v", broadcasts, failures, elapsed)
+	}
+}
+
+func TestGroupLeaveOnClose(t *testing.T) {
+	s := NewUserConfServer(&gconf.Config{}).(*Server)
+	started := make(chan struct{})
+	s.SetOnConnStart(func(giface.IConnection) { close(started) })
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := newServerConn(s, local, 1)
+	go conn.Start()
+	<-started
+
+	for _, group := range []string{"a", "b"} {
+		if err := s.GroupMgr.Join(group, conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.GroupMgr.Count("a")+s.GroupMgr.Count("b") > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("groups %v still joined after the connection closed", s.GroupMgr.Groups(conn))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.GroupMgr.Join("a", conn); err == nil {
+		t.Fatal("Join() of a closed connection err = nil")
+	}
+}
@@ -0,0 +1,381 @@
+package gnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/glog"
+)
+
+const (
+	// The first inherited file descriptor, 0-2 are stdin, stdout and stderr (第一个继承的文件描述符，0-2为标准输入输出)
+	listenFdsStart = 3
+
+	// systemd socket activation, LISTEN_FDS is only honored when LISTEN_PID is the current process
+	// (systemd套接字激活，只有LISTEN_PID为当前进程时LISTEN_FDS才生效)
+	envListenPid     = "LISTEN_PID"
+	envListenFds     = "LISTEN_FDS"
+	envListenFdNames = "LISTEN_FDNAMES"
+
+	// Set by GracefulRestart, the parent can not know the pid of the child before exec
+	// (由GracefulRestart设置，父进程在exec之前无法得知子进程的pid)
+	envGrayListenFds = "GRAY_LISTEN_FDS"
+	// Set by GracefulRestart, the fd of the pipe the child writes to once it has taken the sockets over
+	// (由GracefulRestart设置，子进程接管socket后向该fd对应的管道写入就绪信号)
+	envGrayReadyFd = "GRAY_READY_FD"
+)
+
+// filer is implemented by the sockets whose file descriptor can be handed to a child process
+// (可以将文件描述符交给子进程的socket实现该接口)
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handoffSocket is a socket served by the server that GracefulRestart passes to the child
+// (GracefulRestart交给子进程的、由服务器提供服务的socket)
+type handoffSocket struct {
+	mode   string
+	socket filer
+}
+
+// inheritedSockets holds the listeners inherited from systemd or the parent process until a server takes them
+// (保存从systemd或父进程继承的监听，直到被服务器取走)
+var inheritedSockets struct {
+	once        sync.Once
+	lock        sync.Mutex
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+	ready       *os.File // the readiness pipe to the parent, nil once notified (通知父进程就绪的管道，通知后为nil)
+}
+
+// loadInheritedSockets converts the inherited file descriptors to listeners once and clears the environment,
+// so the variables are not passed on to the children of this process
+// (将继承的文件描述符转换为监听，只执行一次，并清理环境变量，避免传给本进程的子进程)
+func loadInheritedSockets() {
+	inheritedSockets.once.Do(func() {
+		n := inheritedFdCount()
+
+		names := strings.Split(os.Getenv(envListenFdNames), ":")
+		if fd, err := strconv.Atoi(os.Getenv(envGrayReadyFd)); err == nil && fd >= listenFdsStart+n {
+			inheritedSockets.ready = os.NewFile(uintptr(fd), "ready")
+		}
+		for _, env := range []string{envListenPid, envListenFds, envListenFdNames, envGrayListenFds, envGrayReadyFd} {
+			_ = os.Unsetenv(env)
+		}
+
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("fd%d", listenFdsStart+i)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			f := os.NewFile(uintptr(listenFdsStart+i), name)
+
+			// FileListener and FilePacketConn dup the descriptor, so f can be closed
+			// (FileListener和FilePacketConn会复制描述符，因此可以关闭f)
+			if listener, err := net.FileListener(f); err == nil {
+				inheritedSockets.listeners = append(inheritedSockets.listeners, listener)
+				glog.Ins().InfoF("[START] inherited listener %s at %s", name, listener.Addr())
+			} else if conn, err := net.FilePacketConn(f); err == nil {
+				inheritedSockets.packetConns = append(inheritedSockets.packetConns, conn)
+				glog.Ins().InfoF("[START] inherited packet conn %s at %s", name, conn.LocalAddr())
+			} else {
+				glog.Ins().ErrorF("[START] inherited fd %s is neither a listener nor a packet conn: %v", name, err)
+			}
+			_ = f.Close()
+		}
+	})
+}
+
+// inheritedFdCount returns the number of file descriptors passed by systemd or GracefulRestart
+// (返回systemd或GracefulRestart传入的文件描述符数量)
+func inheritedFdCount() int {
+	if n, err := strconv.Atoi(os.Getenv(envGrayListenFds)); err == nil && n > 0 {
+		return n
+	}
+
+	if pid, err := strconv.Atoi(os.Getenv(envListenPid)); err != nil || pid != os.Getpid() {
+		return 0
+	}
+	if n, err := strconv.Atoi(os.Getenv(envListenFds)); err == nil && n > 0 {
+		return n
+	}
+	return 0
+}
+
+// takeInheritedListener removes and returns the inherited listener bound to host:port ("tcp")
+// or to the socket path host ("unix"), nil if there is none
+// (取走绑定在host:port("tcp")或socket路径host("unix")上的继承监听，没有时返回nil)
+func takeInheritedListener(network string, host string, port int) net.Listener {
+	loadInheritedSockets()
+
+	inheritedSockets.lock.Lock()
+	defer inheritedSockets.lock.Unlock()
+
+	for i, listener := range inheritedSockets.listeners {
+		if matchInheritedAddr(listener.Addr(), network, host, port) {
+			inheritedSockets.listeners = append(inheritedSockets.listeners[:i], inheritedSockets.listeners[i+1:]...)
+			notifyHandoffReady()
+			return listener
+		}
+	}
+	return nil
+}
+
+// takeInheritedPacketConn removes and returns the inherited packet conn bound to host:port, nil if there is none
+// (取走绑定在host:port上的继承PacketConn，没有时返回nil)
+func takeInheritedPacketConn(host string, port int) net.PacketConn {
+	loadInheritedSockets()
+
+	inheritedSockets.lock.Lock()
+	defer inheritedSockets.lock.Unlock()
+
+	for i, conn := range inheritedSockets.packetConns {
+		if matchInheritedAddr(conn.LocalAddr(), "udp", host, port) {
+			inheritedSockets.packetConns = append(inheritedSockets.packetConns[:i], inheritedSockets.packetConns[i+1:]...)
+			notifyHandoffReady()
+			return conn
+		}
+	}
+	return nil
+}
+
+// TakeInheritedListeners removes and returns the inherited listeners and packet conns that no configured
+// listener has matched, they can be served with ServeListener, ServeWebsocketListener or ServeKcpPacketConn
+// (取走没有被配置的监听匹配到的继承监听和PacketConn，可以使用ServeListener、ServeWebsocketListener或ServeKcpPacketConn提供服务)
+func TakeInheritedListeners() ([]net.Listener, []net.PacketConn) {
+	loadInheritedSockets()
+
+	inheritedSockets.lock.Lock()
+	defer inheritedSockets.lock.Unlock()
+
+	listeners, packetConns := inheritedSockets.listeners, inheritedSockets.packetConns
+	inheritedSockets.listeners, inheritedSockets.packetConns = nil, nil
+	if len(listeners)+len(packetConns) > 0 {
+		notifyHandoffReady()
+	}
+	return listeners, packetConns
+}
+
+// notifyHandoffReady tells the parent that called GracefulRestart that every handed socket has been taken over,
+// it must be called with inheritedSockets.lock held
+// (通知调用GracefulRestart的父进程所有socket均已被接管，调用时必须持有inheritedSockets.lock)
+func notifyHandoffReady() {
+	if inheritedSockets.ready == nil || len(inheritedSockets.listeners)+len(inheritedSockets.packetConns) > 0 {
+		return
+	}
+	if _, err := inheritedSockets.ready.Write([]byte{1}); err != nil {
+		glog.Ins().ErrorF("[START] notify the parent process of readiness err: %v", err)
+	}
+	_ = inheritedSockets.ready.Close()
+	inheritedSockets.ready = nil
+}
+
+// matchInheritedAddr reports whether addr is the address configured by host and port,
+// an unspecified IP on either side matches any IP of the same port
+// (判断addr是否为host和port配置的地址，任意一方为未指定IP时只比较端口)
+func matchInheritedAddr(addr net.Addr, network string, host string, port int) bool {
+	var ip net.IP
+	var addrPort int
+
+	switch a := addr.(type) {
+	case *net.UnixAddr:
+		return network == "unix" && a.Name == host
+	case *net.TCPAddr:
+		if network != "tcp" {
+			return false
+		}
+		ip, addrPort = a.IP, a.Port
+	case *net.UDPAddr:
+		if network != "udp" {
+			return false
+		}
+		ip, addrPort = a.IP, a.Port
+	default:
+		return false
+	}
+
+	if port == 0 || addrPort != port {
+		return false
+	}
+
+	hostIP := net.ParseIP(host)
+	if host == "" || hostIP == nil || hostIP.IsUnspecified() || ip == nil || ip.IsUnspecified() {
+		return true
+	}
+	return hostIP.Equal(ip)
+}
+
+// addHandoffSocket records a socket served by the server, so GracefulRestart can pass it to the child
+// (记录服务器提供服务的socket，使GracefulRestart可以将其交给子进程)
+func (s *Server) addHandoffSocket(mode string, socket interface{}) {
+	if pl, ok := socket.(*proxyProtoListener); ok {
+		socket = pl.Listener
+	}
+	f, ok := socket.(filer)
+	if !ok {
+		return
+	}
+
+	s.addrLock.Lock()
+	defer s.addrLock.Unlock()
+
+	s.handoffSockets = append(s.handoffSockets, handoffSocket{mode: mode, socket: f})
+}
+
+// GracefulRestart starts a new copy of the executable with the same arguments and hands it the listening
+// sockets of the server. Once the child reports that its listeners have taken every socket over, this server
+// is gracefully shut down with Shutdown(ctx), so no client connecting meanwhile is refused. If the child exits,
+// closes the readiness pipe or is not ready before ctx expires, it is killed, this server keeps serving and
+// an error is returned. A server serving KCP can not be restarted this way, an error is returned before the
+// child is started: both processes would read the shared UDP socket while this one drains, so the datagrams
+// of its KCP sessions would reach the child at random.
+// (以相同的参数启动一个新的可执行文件副本，并将服务器的监听socket交给它。子进程报告其监听已接管全部socket后，
+// 通过Shutdown(ctx)优雅关闭本服务，因此期间连接的客户端不会被拒绝。若子进程退出、关闭了就绪管道或在ctx到期前
+// 未就绪，则杀死子进程，本服务继续运行并返回错误。提供KCP服务的服务器不能以这种方式重启，在启动子进程之前即返回错误：
+// 本进程排空期间两个进程会同时读取共享的UDP socket，其KCP会话的数据报会随机到达子进程)
+func (s *Server) GracefulRestart(ctx context.Context) error {
+	s.addrLock.RLock()
+	sockets := append([]handoffSocket(nil), s.handoffSockets...)
+	s.addrLock.RUnlock()
+
+	if len(sockets) == 0 {
+		return fmt.Errorf("graceful restart: server %s has no listening socket to hand off", s.Name)
+	}
+	for _, hs := range sockets {
+		if hs.mode == gconf.ServerModeKcp {
+			return fmt.Errorf("graceful restart: server %s serves KCP, its UDP socket can not be shared with the child while the KCP sessions drain", s.Name)
+		}
+	}
+
+	// 1. Duplicate the sockets for the child (为子进程复制socket)
+	files := make([]*os.File, 0, len(sockets))
+	names := make([]string, 0, len(sockets))
+	var unixListeners []*net.UnixListener
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	// The socket files are only left to the child once it is ready (子进程就绪后socket文件才交给它)
+	keepUnixSockets := func(keep bool) {
+		for _, ul := range unixListeners {
+			ul.SetUnlinkOnClose(!keep)
+		}
+	}
+	for _, hs := range sockets {
+		// The socket file belongs to the child from now on, closing our listener must not remove it
+		// (socket文件此后属于子进程，关闭本进程的监听时不能删除它)
+		if ul, ok := hs.socket.(*net.UnixListener); ok {
+			unixListeners = append(unixListeners, ul)
+			ul.SetUnlinkOnClose(false)
+		}
+		f, err := hs.socket.File()
+		if err != nil {
+			keepUnixSockets(false)
+			return fmt.Errorf("graceful restart: dup %s socket: %w", hs.mode, err)
+		}
+		files = append(files, f)
+		names = append(names, hs.mode)
+	}
+
+	// 2. Start the child with the sockets as fd 3, 4, ... and the readiness pipe after them
+	// (以fd 3, 4, ...将socket传给子进程，就绪管道排在其后，并启动子进程)
+	executable, err := os.Executable()
+	if err != nil {
+		keepUnixSockets(false)
+		return fmt.Errorf("graceful restart: %w", err)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		keepUnixSockets(false)
+		return fmt.Errorf("graceful restart: readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File(nil), files...), readyW)
+	cmd.Env = append(handoffEnviron(),
+		fmt.Sprintf("%s=%d", envGrayListenFds, len(files)),
+		fmt.Sprintf("%s=%s", envListenFdNames, strings.Join(names, ":")),
+		fmt.Sprintf("%s=%d", envGrayReadyFd, listenFdsStart+len(files)),
+	)
+	err = cmd.Start()
+	// Only the child keeps the write end, so the read end sees EOF once the child exits
+	// (只有子进程持有写端，因此子进程退出后读端会读到EOF)
+	_ = readyW.Close()
+	if err != nil {
+		keepUnixSockets(false)
+		return fmt.Errorf("graceful restart: start child: %w", err)
+	}
+	glog.Ins().InfoF("[RESTART] Gray server , name %s, handed %d sockets to child pid %d", s.Name, len(files), cmd.Process.Pid)
+
+	// 3. Wait until the child has taken the sockets over, this server keeps serving if it fails
+	// (等待子进程接管socket，若子进程失败则本服务继续运行)
+	if err = waitChildReady(ctx, cmd, readyR); err != nil {
+		keepUnixSockets(false)
+		return fmt.Errorf("graceful restart: child pid %d: %w", cmd.Process.Pid, err)
+	}
+	glog.Ins().InfoF("[RESTART] Gray server , name %s, child pid %d is ready", s.Name, cmd.Process.Pid)
+
+	// 4. Stop accepting and drain our own connections (停止接收新连接并排空本进程的连接)
+	return s.Shutdown(ctx)
+}
+
+// waitChildReady waits for the readiness signal of the child started by GracefulRestart, the child is killed
+// if it does not become ready (等待GracefulRestart启动的子进程发出就绪信号，子进程未能就绪时将其杀死)
+func waitChildReady(ctx context.Context, cmd *exec.Cmd, ready *os.File) error {
+	// Wait reaps the child whenever it exits (子进程退出时由Wait回收)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	signaled := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		signaled <- err
+	}()
+
+	var err error
+	select {
+	case err = <-signaled:
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("readiness pipe closed before ready: %w", err)
+	case err = <-exited:
+		if err == nil {
+			return errors.New("exited before ready")
+		}
+		err = fmt.Errorf("exited before ready: %w", err)
+	case <-ctx.Done():
+		err = fmt.Errorf("not ready: %w", ctx.Err())
+	}
+
+	_ = cmd.Process.Kill()
+	return err
+}
+
+// handoffEnviron returns the environment of this process without the socket activation variables
+// (返回去掉了套接字激活变量的当前进程环境变量)
+func handoffEnviron() []string {
+	environ := os.Environ()
+	env := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case envListenPid, envListenFds, envListenFdNames, envGrayListenFds, envGrayReadyFd:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,72 @@
+package gnet
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+)
+
+func TestGracefulRestartRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		tcp     bool
+		kcp     bool
+		wantErr string
+	}{
+		{name: "no socket", wantErr: "no listening socket"},
+		{name: "kcp", kcp: true, wantErr: "serves KCP"},
+		{name: "tcp and kcp", tcp: true, kcp: true, wantErr: "serves KCP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUserConfServer(&gconf.Config{}).(*Server)
+			defer s.Stop()
+
+			sockets := 0
+			if tt.tcp {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go s.ServeListener(listener)
+				sockets++
+			}
+			if tt.kcp {
+				conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go s.ServeKcpPacketConn(conn)
+				sockets++
+			}
+			// Wait until the sockets are recorded for the handoff (等待socket被记录用于交接)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				s.addrLock.RLock()
+				n := len(s.handoffSockets)
+				s.addrLock.RUnlock()
+				if n == sockets {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%d sockets recorded, want %d", n, sockets)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			err := s.GracefulRestart(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("GracefulRestart() err = %v, want %q", err, tt.wantErr)
+			}
+			// The refused server keeps serving (被拒绝后服务继续运行)
+			if s.isStopping() {
+				t.Fatal("the server is stopping after the restart was refused")
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package gnet
+
+import (
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liyee/gray/glog"
+)
+
+const (
+	// The minimum interval between two rejection logs, the rejections in between are only counted
+	// (两条拒绝日志之间的最小间隔，期间的拒绝只计数不打印)
+	rejectLogInterval = 5 * time.Second
+	// The interval of removing idle IP entries (清理空闲IP记录的间隔)
+	ipSweepInterval = time.Minute
+)
+
+// The reasons of rejecting a new connection (拒绝新连接的原因)
+const (
+	rejectConnLimit = "per-ip connection limit"
+	rejectRateLimit = "per-ip accept rate limit"
+)
+
+// ipEntry is the admission state of one remote IP (单个远端IP的准入状态)
+type ipEntry struct {
+	conns  int       // concurrent connections (当前并发链接数)
+	tokens float64   // tokens left in the bucket (令牌桶中剩余的令牌)
+	last   time.Time // last refill time (上次补充令牌的时间)
+}
+
+// IPLimiterStats is a snapshot of the counters of an IPLimiter
+// (IPLimiter计数器的快照)
+type IPLimiterStats struct {
+	ConnLimitRejected uint64 // rejected by MaxConnPerIP (因单IP最大链接数被拒绝的次数)
+	RateLimitRejected uint64 // rejected by AcceptRatePerIP (因单IP建链速率被拒绝的次数)
+	TrackedIPs        int    // IPs being tracked (当前跟踪的IP数)
+}
+
+// IPLimiter caps the concurrent connections of every remote IP and limits the rate
+// at which each IP may open new connections with a token bucket.
+// (限制每个远端IP的并发链接数，并用令牌桶限制每个IP建立新链接的速率)
+type IPLimiter struct {
+	maxConn int
+	rate    float64
+	burst   float64
+
+	lock      sync.Mutex
+	entries   map[string]*ipEntry
+	lastSweep time.Time
+
+	connRejected uint64
+	rateRejected uint64
+
+	logLock    sync.Mutex
+	lastLog    time.Time
+	suppressed uint64
+}
+
+// NewIPLimiter creates an IPLimiter, maxConn or rate <= 0 disables the corresponding limit.
+// burst <= 0 means max(1, rate).
+// (创建IPLimiter，maxConn或rate小于等于0时不启用对应限制，burst小于等于0时取max(1, rate))
+func NewIPLimiter(maxConn int, rate float64, burst int) *IPLimiter {
+	l := &IPLimiter{
+		maxConn: maxConn,
+		rate:    rate,
+		burst:   float64(burst),
+		entries: make(map[string]*ipEntry),
+	}
+	if l.burst <= 0 {
+		l.burst = math.Max(1, math.Ceil(rate))
+	}
+	return l
+}
+
+// Enabled reports whether any limit is configured (是否配置了任何限制)
+func (l *IPLimiter) Enabled() bool {
+	return l != nil && (l.maxConn > 0 || l.rate > 0)
+}
+
+// Acquire admits a new connection from ip. It returns false and records the rejection if the
+// connection exceeds a limit; otherwise Release must be called once the connection is closed.
+// An empty ip (e.g. unix sockets) is always admitted.
+// (接纳来自ip的新连接。超出限制时返回false并记录拒绝；否则在连接关闭后必须调用Release。空ip(如unix套接字)总是被接纳)
+func (l *IPLimiter) Acquire(ip string) bool {
+	if !l.Enabled() || ip == "" {
+		return true
+	}
+
+	now := time.Now()
+
+	l.lock.Lock()
+	l.sweep(now)
+
+	e, ok := l.entries[ip]
+	if !ok {
+		e = &ipEntry{tokens: l.burst, last: now}
+		l.entries[ip] = e
+	}
+
+	// 1. Concurrent connection cap (并发链接数上限)
+	if l.maxConn > 0 && e.conns >= l.maxConn {
+		l.lock.Unlock()
+		atomic.AddUint64(&l.connRejected, 1)
+		l.logReject(ip, rejectConnLimit, now)
+		return false
+	}
+
+	// 2. Token bucket (令牌桶)
+	if l.rate > 0 {
+		l.refill(e, now)
+		if e.tokens < 1 {
+			l.lock.Unlock()
+			atomic.AddUint64(&l.rateRejected, 1)
+			l.logReject(ip, rejectRateLimit, now)
+			return false
+		}
+		e.tokens--
+	}
+
+	e.conns++
+	l.lock.Unlock()
+	return true
+}
+
+// Release gives back the connection slot taken by a successful Acquire
+// (归还Acquire成功时占用的链接名额)
+func (l *IPLimiter) Release(ip string) {
+	if !l.Enabled() || ip == "" {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	e, ok := l.entries[ip]
+	if !ok {
+		return
+	}
+	if e.conns > 0 {
+		e.conns--
+	}
+	// Without a rate limit there is nothing left to remember
+	// (未启用速率限制时无需保留该记录)
+	if e.conns == 0 && l.rate <= 0 {
+		delete(l.entries, ip)
+	}
+}
+
+// Stats returns the rejection counters (返回拒绝计数)
+func (l *IPLimiter) Stats() IPLimiterStats {
+	if l == nil {
+		return IPLimiterStats{}
+	}
+
+	l.lock.Lock()
+	tracked := len(l.entries)
+	l.lock.Unlock()
+
+	return IPLimiterStats{
+		ConnLimitRejected: atomic.LoadUint64(&l.connRejected),
+		RateLimitRejected: atomic.LoadUint64(&l.rateRejected),
+		TrackedIPs:        tracked,
+	}
+}
+
+// refill adds the tokens earned since the last refill, must be called with lock held
+// (补充自上次以来获得的令牌，调用时必须持有锁)
+func (l *IPLimiter) refill(e *ipEntry, now time.Time) {
+	elapsed := now.Sub(e.last).Seconds()
+	if elapsed > 0 {
+		e.tokens = math.Min(l.burst, e.tokens+elapsed*l.rate)
+		e.last = now
+	}
+}
+
+// sweep removes the entries without connections whose bucket is full again, must be called with lock held
+// (清理没有连接且令牌桶已满的记录，调用时必须持有锁)
+func (l *IPLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < ipSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for ip, e := range l.entries {
+		if e.conns > 0 {
+			continue
+		}
+		l.refill(e, now)
+		if e.tokens >= l.burst {
+			delete(l.entries, ip)
+		}
+	}
+}
+
+// logReject logs a rejection at most once every rejectLogInterval
+// (每rejectLogInterval最多打印一次拒绝日志)
+func (l *IPLimiter) logReject(ip string, reason string, now time.Time) {
+	l.logLock.Lock()
+	if now.Sub(l.lastLog) < rejectLogInterval {
+		l.suppressed++
+		l.logLock.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.suppressed = 0
+	l.lastLog = now
+	l.logLock.Unlock()
+
+	stats := l.Stats()
+	glog.Ins().InfoF("Reject connection from %s: %s, %d similar rejections suppressed, total rejected conn limit:%d rate limit:%d",
+		ip, reason, suppressed, stats.ConnLimitRejected, stats.RateLimitRejected)
+}
+
+// remoteIP returns the IP part of a remote address, or "" if the address has no IP
+// (返回远端地址中的IP部分，地址不含IP时返回"")
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
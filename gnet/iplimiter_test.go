@@ -0,0 +1,131 @@
+package gnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPLimiterAcquire(t *testing.T) {
+	const ip = "10.0.0.1"
+
+	// Each step either acquires, releases or lets time pass (每一步为获取、释放或经过一段时间)
+	type step struct {
+		release bool
+		elapse  time.Duration
+		want    bool
+	}
+
+	tests := []struct {
+		name    string
+		maxConn int
+		rate    float64
+		burst   int
+		steps   []step
+	}{
+		{
+			name:  "disabled",
+			steps: []step{{want: true}, {want: true}, {want: true}},
+		},
+		{
+			name:    "connection cap",
+			maxConn: 2,
+			steps:   []step{{want: true}, {want: true}, {want: false}, {release: true}, {want: true}, {want: false}},
+		},
+		{
+			name:  "burst then empty bucket",
+			rate:  1,
+			burst: 2,
+			steps: []step{{want: true}, {want: true}, {want: false}},
+		},
+		{
+			name:  "default burst is the rate",
+			rate:  3,
+			steps: []step{{want: true}, {want: true}, {want: true}, {want: false}},
+		},
+		{
+			name:  "refill after time passes",
+			rate:  2,
+			burst: 1,
+			steps: []step{{want: true}, {want: false}, {elapse: 500 * time.Millisecond}, {want: true}, {want: false}},
+		},
+		{
+			name:  "refill is capped by burst",
+			rate:  10,
+			burst: 2,
+			steps: []step{{want: true}, {want: true}, {elapse: time.Hour}, {want: true}, {want: true}, {want: false}},
+		},
+		{
+			name:    "release does not give back tokens",
+			maxConn: 5,
+			rate:    1,
+			burst:   1,
+			steps:   []step{{want: true}, {release: true}, {want: false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewIPLimiter(tt.maxConn, tt.rate, tt.burst)
+			for i, st := range tt.steps {
+				switch {
+				case st.release:
+					l.Release(ip)
+				case st.elapse > 0:
+					// Move the last refill back instead of sleeping (回拨上次补充时间以代替等待)
+					l.lock.Lock()
+					if e, ok := l.entries[ip]; ok {
+						e.last = e.last.Add(-st.elapse)
+					}
+					l.lock.Unlock()
+				default:
+					if got := l.Acquire(ip); got != st.want {
+						t.Fatalf("step %d: Acquire() = %v, want %v", i, got, st.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestIPLimiterStats(t *testing.T) {
+	l := NewIPLimiter(1, 1, 1)
+	l.Acquire("10.0.0.1")
+	l.Acquire("10.0.0.1") // over the connection cap (超过链接数上限)
+	l.Release("10.0.0.1")
+	l.Acquire("10.0.0.1") // out of tokens (令牌耗尽)
+	l.Acquire("10.0.0.2")
+
+	want := IPLimiterStats{ConnLimitRejected: 1, RateLimitRejected: 1, TrackedIPs: 2}
+	if got := l.Stats(); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestIPLimiterEmptyIP(t *testing.T) {
+	l := NewIPLimiter(1, 1, 1)
+	for i := 0; i < 3; i++ {
+		if !l.Acquire("") {
+			t.Fatalf("Acquire(\"\") #%d = false, want true", i)
+		}
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "127.0.0.1:8999", want: "127.0.0.1"},
+		{addr: "[::1]:8999", want: "::1"},
+		{addr: "127.0.0.1", want: ""},
+		{addr: "/tmp/gray.sock", want: ""},
+		{addr: "localhost:8999", want: ""},
+		{addr: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := remoteIP(tt.addr); got != tt.want {
+			t.Errorf("remoteIP(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package gnet
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"net"
@@ -45,6 +47,9 @@ type KcpConnection struct {
 	// (消息管理MsgID和对应处理方法的消息管理模块)
 	msgHandler giface.IMsgHandler
 
+	// The config inherited from the Server or Client (从Server或Client继承的配置)
+	config *gconf.Config
+
 	// Channel to notify that the connection has exited/stopped
 	// (告知该链接已经退出/停止的channel)
 	ctx    context.Context
@@ -54,6 +59,10 @@ type KcpConnection struct {
 	// (有缓冲管道，用于读、写两个goroutine之间的消息通信)
 	msgBuffChan chan []byte
 
+	// Number of buffered messages that have not been written yet
+	// (发送缓冲队列中尚未写出的消息数量)
+	pendingBuffMsg int64
+
 	// Lock for user message reception and transmission
 	// (用户收发消息的Lock)
 	msgLock sync.RWMutex
@@ -86,9 +95,9 @@ type KcpConnection struct {
 	// (数据报文封包方式)
 	packet giface.IDataPack
 
-	// Last activity time
-	// (最后一次活动时间)
-	lastActivityTime time.Time
+	// Last activity time in UnixNano, accessed atomically since the admin endpoints read it
+	// (最后一次活动时间(UnixNano)，管理接口会读取，因此使用原子操作访问)
+	lastActivityTime int64
 
 	// Framedecoder for solving fragmentation and packet sticking problems
 	// (断粘包解码器)
@@ -115,6 +124,10 @@ type KcpConnection struct {
 
 	// Close callback mutex
 	closeCallbackMutex sync.RWMutex
+
+	// Pending calls waiting for their replies
+	// (等待应答的调用)
+	calls callTable
 }
 
 // newKcpServerConn :for Server, method to create a Server-side connection with Server-specific properties
@@ -131,6 +144,8 @@ func newKcpServerConn(server giface.IServer, conn *kcp.UDPSession, connID uint64
 		localAddr:   conn.LocalAddr().String(),
 		remoteAddr:  conn.RemoteAddr().String(),
 	}
+	// The context exists from the start so Stop works before Start (context在创建时即存在，使Start之前也可以Stop)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := server.GetLengthField()
 	if lengthField != nil {
@@ -139,9 +154,10 @@ func newKcpServerConn(server giface.IServer, conn *kcp.UDPSession, connID uint64
 
 	// Inherited properties from server (从server继承过来的属性)
 	c.packet = server.GetPacket()
-	c.onConnStart = server.GetOnConnStart()
+	c.onConnStart = onConnStartOf(server)
 	c.onConnStop = server.GetOnConnStop()
 	c.msgHandler = server.GetMsgHandler()
+	c.config = configOf(server)
 
 	// Bind the current Connection with the Server's ConnManager
 	// (将当前的Connection与Server的ConnManager绑定)
@@ -167,6 +183,7 @@ func newKcpClientConn(client giface.IClient, conn *kcp.UDPSession) giface.IConne
 		localAddr:   conn.LocalAddr().String(),
 		remoteAddr:  conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := client.GetLengthField()
 	if lengthField != nil {
@@ -175,9 +192,10 @@ func newKcpClientConn(client giface.IClient, conn *kcp.UDPSession) giface.IConne
 
 	// Inherited properties from server (从client继承过来的属性)
 	c.packet = client.GetPacket()
-	c.onConnStart = client.GetOnConnStart()
+	c.onConnStart = onClientConnStartOf(client)
 	c.onConnStop = client.GetOnConnStop()
 	c.msgHandler = client.GetMsgHandler()
+	c.config = configOf(client)
 
 	return c
 }
@@ -192,7 +210,9 @@ func (c *KcpConnection) StartWriter() {
 		select {
 		case data, ok := <-c.msgBuffChan:
 			if ok {
-				if err := c.Send(data); err != nil {
+				err := c.Send(data)
+				atomic.AddInt64(&c.pendingBuffMsg, -1)
+				if err != nil {
 					glog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
 					break
 				}
@@ -225,7 +245,7 @@ func (c *KcpConnection) StartReader() {
 			return
 		default:
 			// add by uuxia 2023-02-03
-			buffer := make([]byte, gconf.GlobalObject.IOReadBuffSize)
+			buffer := make([]byte, c.config.IOReadBuffSize)
 
 			// read data from the connection's IO into the memory buffer
 			// (从conn的IO中读取数据到内存缓冲buffer中)
@@ -278,7 +298,12 @@ func (c *KcpConnection) Start() {
 			glog.Ins().ErrorF("Connection Start() error: %v", err)
 		}
 	}()
-	c.ctx, c.cancel = context.WithCancel(context.Background())
+	// Stopped before it was started, e.g. by ClearConn while the server shuts down
+	// (启动之前已被停止，例如服务关闭时被ClearConn停止)
+	if c.ctx.Err() != nil {
+		c.abort()
+		return
+	}
 
 	// Execute the hook method for processing business logic when creating a connection
 	// (按照用户传递进来的创建连接时需要处理的业务，执行钩子方法)
@@ -313,6 +338,28 @@ func (c *KcpConnection) Stop() {
 	c.cancel()
 }
 
+// abort closes a connection that is not going to be started (关闭不会再启动的连接)
+func (c *KcpConnection) abort() {
+	if !c.setClose() {
+		return
+	}
+	_ = c.conn.Close()
+	if c.connManager != nil {
+		c.connManager.Remove(c)
+	}
+
+	// Close callbacks may have been added before Start, e.g. by joining a group (启动前可能已添加关闭回调，例如加入分组)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				glog.Ins().ErrorF("Conn abort panic: %v", err)
+			}
+		}()
+
+		c.InvokeCloseCallbacks()
+	}()
+}
+
 func (c *KcpConnection) GetConnection() net.Conn {
 	return c.conn
 }
@@ -346,6 +393,16 @@ func (c *KcpConnection) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
 }
 
+// GetPeerCertificates always returns nil, KCP connections do not use TLS
+// (KCP连接不使用TLS，总是返回nil)
+func (c *KcpConnection) GetPeerCertificates() []*x509.Certificate {
+	return nil
+}
+
+func (c *KcpConnection) GetTLSConnectionState() (tls.ConnectionState, bool) {
+	return tls.ConnectionState{}, false
+}
+
 func (c *KcpConnection) Send(data []byte) error {
 	c.msgLock.RLock()
 	defer c.msgLock.RUnlock()
@@ -363,11 +420,23 @@ func (c *KcpConnection) Send(data []byte) error {
 }
 
 func (c *KcpConnection) SendToQueue(data []byte) error {
+	return c.sendToQueue(data, true)
+}
+
+// trySendToQueue puts data into the send queue without waiting, it fails at once if the queue is full
+// (不等待地将data放入发送队列，队列已满时立即失败)
+func (c *KcpConnection) trySendToQueue(data []byte) error {
+	return c.sendToQueue(data, false)
+}
+
+// sendToQueue puts data into the send queue, waiting for a free slot for a while if wait is set
+// (将data放入发送队列，设置wait时会等待一段时间直到有空位)
+func (c *KcpConnection) sendToQueue(data []byte, wait bool) error {
 	c.msgLock.RLock()
 	defer c.msgLock.RUnlock()
 
 	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, gconf.GlobalObject.MaxMsgChanLen)
+		c.msgBuffChan = make(chan []byte, c.config.MaxMsgChanLen)
 		// Start a Goroutine to write data back to the client
 		// This method only reads data from the MsgBuffChan without allocating memory or starting a Goroutine
 		// (开启用于写回客户端数据流程的Goroutine
@@ -375,9 +444,6 @@ func (c *KcpConnection) SendToQueue(data []byte) error {
 		go c.StartWriter()
 	}
 
-	idleTimeout := time.NewTimer(5 * time.Millisecond)
-	defer idleTimeout.Stop()
-
 	if c.isClosed() {
 		return errors.New("Connection closed when send buff msg")
 	}
@@ -387,9 +453,28 @@ func (c *KcpConnection) SendToQueue(data []byte) error {
 		return errors.New("Pack data is nil")
 	}
 
+	// Count the message before queuing it, the writer may dequeue it before the send returns
+	// (入队前先计数，因为发送返回前写协程可能已经取出该消息)
+	atomic.AddInt64(&c.pendingBuffMsg, 1)
+	if !wait {
+		select {
+		case c.msgBuffChan <- data:
+			return nil
+		default:
+			atomic.AddInt64(&c.pendingBuffMsg, -1)
+			metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
+			return errSendQueueFull
+		}
+	}
+
+	idleTimeout := time.NewTimer(5 * time.Millisecond)
+	defer idleTimeout.Stop()
+
 	// Send timeout
 	select {
 	case <-idleTimeout.C:
+		atomic.AddInt64(&c.pendingBuffMsg, -1)
+		metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
 		return errors.New("send buff msg timeout")
 	case c.msgBuffChan <- data:
 		return nil
@@ -414,6 +499,7 @@ func (c *KcpConnection) SendMsg(msgID uint32, data []byte) error {
 		glog.Ins().ErrorF("SendMsg err msg ID = %d, data = %+v, err = %+v", msgID, string(msg), err)
 		return err
 	}
+	metricsOf(c.msgHandler).msgSent(msgID, len(data))
 
 	return nil
 }
@@ -423,7 +509,7 @@ func (c *KcpConnection) SendBuffMsg(msgID uint32, data []byte) error {
 		return errors.New("connection closed when send buff msg")
 	}
 	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, gconf.GlobalObject.MaxMsgChanLen)
+		c.msgBuffChan = make(chan []byte, c.config.MaxMsgChanLen)
 		// Start a Goroutine to write data back to the client
 		// This method only reads data from the MsgBuffChan without allocating memory or starting a Goroutine
 		// (开启用于写回客户端数据流程的Goroutine
@@ -440,15 +526,31 @@ func (c *KcpConnection) SendBuffMsg(msgID uint32, data []byte) error {
 		return errors.New("Pack error msg ")
 	}
 
+	// Count the message before queuing it, the writer may dequeue it before the send returns
+	// (入队前先计数，因为发送返回前写协程可能已经取出该消息)
+	atomic.AddInt64(&c.pendingBuffMsg, 1)
 	// send timeout
 	select {
 	case <-idleTimeout.C:
+		atomic.AddInt64(&c.pendingBuffMsg, -1)
+		metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
 		return errors.New("send buff msg timeout")
 	case c.msgBuffChan <- msg:
+		metricsOf(c.msgHandler).msgSent(msgID, len(data))
 		return nil
 	}
 }
 
+// Call sends data to msgID of the peer and waits for its reply, see giface.IConnection.Call
+// (向对端的msgID发送data并等待应答，见giface.IConnection.Call)
+func (c *KcpConnection) Call(msgID uint32, data []byte, timeout time.Duration) (giface.IMessage, error) {
+	return c.calls.call(c, msgID, data, timeout)
+}
+
+func (c *KcpConnection) resolveCall(corrID uint32, msg giface.IMessage) bool {
+	return c.calls.resolve(corrID, msg)
+}
+
 func (c *KcpConnection) SetProperty(key string, value interface{}) {
 	c.propertyLock.Lock()
 	defer c.propertyLock.Unlock()
@@ -477,6 +579,33 @@ func (c *KcpConnection) RemoveProperty(key string) {
 	delete(c.property, key)
 }
 
+// GetProperties returns a copy of all the connection properties (返回所有链接属性的副本)
+func (c *KcpConnection) GetProperties() map[string]interface{} {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+
+	properties := make(map[string]interface{}, len(c.property))
+	for k, v := range c.property {
+		properties[k] = v
+	}
+	return properties
+}
+
+// LastActivity returns the last time data was received from the peer (返回最后一次收到对端数据的时间)
+func (c *KcpConnection) LastActivity() time.Time {
+	nano := atomic.LoadInt64(&c.lastActivityTime)
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// SendQueueLen returns the number of buffered messages not written yet and the capacity of the send queue
+// (返回发送缓冲队列中尚未写出的消息数量及队列容量)
+func (c *KcpConnection) SendQueueLen() (int, int) {
+	return int(atomic.LoadInt64(&c.pendingBuffMsg)), int(c.config.MaxMsgChanLen)
+}
+
 func (c *KcpConnection) Context() context.Context {
 	return c.ctx
 }
@@ -551,11 +680,11 @@ func (c *KcpConnection) IsAlive() bool {
 	// Check the last activity time of the connection. If it's beyond the heartbeat interval,
 	// then the connection is considered dead.
 	// (检查连接最后一次活动时间，如果超过心跳间隔，则认为连接已经死亡)
-	return time.Now().Sub(c.lastActivityTime) < gconf.GlobalObject.HeartbeatMaxDuration()
+	return time.Since(c.LastActivity()) < c.config.HeartbeatMaxDuration()
 }
 
 func (c *KcpConnection) updateActivity() {
-	c.lastActivityTime = time.Now()
+	atomic.StoreInt64(&c.lastActivityTime, time.Now().UnixNano())
 }
 
 func (c *KcpConnection) SetHeartBeat(checker giface.IHeartbeatChecker) {
@@ -578,6 +707,15 @@ func (c *KcpConnection) GetMsgHandler() giface.IMsgHandler {
 	return c.msgHandler
 }
 
+func (c *KcpConnection) getPacket() giface.IDataPack {
+	return c.packet
+}
+
+// GetConfig returns the config inherited from the Server or Client (返回从Server或Client继承的配置)
+func (c *KcpConnection) GetConfig() *gconf.Config {
+	return c.config
+}
+
 func (c *KcpConnection) isClosed() bool {
 	return atomic.LoadInt32(&c.closed) != 0
 }
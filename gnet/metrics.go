@@ -0,0 +1,390 @@
+package gnet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/glog"
+	"github.com/liyee/gray/gpack"
+)
+
+const (
+	// metricsMode is the key of the metrics listener address (指标监听地址的键)
+	metricsMode = "metrics"
+
+	// maxMsgIDLabels bounds the distinct msgIDs tracked per metric, the other msgIDs are counted as "other",
+	// so a peer sending random msgIDs can not grow the metrics without limit
+	// (每个指标跟踪的不同msgID数量上限，其余msgID计入"other"，避免对端发送随机msgID导致指标无限增长)
+	maxMsgIDLabels = 1024
+	otherMsgID     = "other"
+)
+
+// Reasons of rejected connections (连接被拒绝的原因)
+const (
+	rejectIPLimit     = "ip_limit"
+	rejectMaxConn     = "max_conn"
+	rejectProxyHeader = "proxy_header"
+	rejectAuth        = "auth"
+	rejectUpgrade     = "upgrade"
+)
+
+// handlerBuckets are the upper bounds in seconds of the handler latency histogram
+// (处理函数耗时直方图的桶上限，单位秒)
+var handlerBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// serverMetrics counts the connections, messages and handler latencies of a server. All the methods are
+// safe on a nil *serverMetrics, so disabled metrics cost nothing.
+// (统计服务器的连接、消息和处理耗时，所有方法在*serverMetrics为nil时都是安全的，关闭指标时没有开销)
+type serverMetrics struct {
+	accepted     counterVec // transport
+	rejected     counterVec // transport, reason
+	closed       counterVec // transport
+	sendTimeouts counterVec // transport
+
+	msgIn    msgIDCounters
+	msgOut   msgIDCounters
+	handlers histogramVec // msgID
+
+	msgIDMask uint32 // clears the call flags when CallMode is on (开启CallMode时清除调用标志位)
+}
+
+func newServerMetrics(callMode bool) *serverMetrics {
+	m := &serverMetrics{msgIDMask: ^uint32(0)}
+	if callMode {
+		m.msgIDMask = gpack.MsgIDMask
+	}
+	return m
+}
+
+type connLabels struct {
+	transport string
+	reason    string
+}
+
+// counterVec is a set of counters keyed by their labels (按标签区分的一组计数器)
+type counterVec struct {
+	counters sync.Map
+}
+
+func (v *counterVec) add(key interface{}, n uint64) {
+	counter, ok := v.counters.Load(key)
+	if !ok {
+		counter, _ = v.counters.LoadOrStore(key, new(uint64))
+	}
+	atomic.AddUint64(counter.(*uint64), n)
+}
+
+func (v *counterVec) each(f func(key interface{}, value uint64)) {
+	v.counters.Range(func(key, counter interface{}) bool {
+		f(key, atomic.LoadUint64(counter.(*uint64)))
+		return true
+	})
+}
+
+// msgIDCounters counts the messages and bytes of each msgID (按msgID统计消息数和字节数)
+type msgIDCounters struct {
+	labels   msgIDLabels
+	messages counterVec
+	bytes    counterVec
+}
+
+func (c *msgIDCounters) add(msgID uint32, size int) {
+	label := c.labels.of(msgID)
+	c.messages.add(label, 1)
+	c.bytes.add(label, uint64(size))
+}
+
+// msgIDLabels turns msgIDs into label values, up to maxMsgIDLabels distinct ones
+// (将msgID转换为标签值，最多maxMsgIDLabels个不同的值)
+type msgIDLabels struct {
+	labels sync.Map
+	count  int32
+}
+
+func (l *msgIDLabels) of(msgID uint32) string {
+	if label, ok := l.labels.Load(msgID); ok {
+		return label.(string)
+	}
+	if atomic.AddInt32(&l.count, 1) > maxMsgIDLabels {
+		atomic.AddInt32(&l.count, -1)
+		return otherMsgID
+	}
+	label, loaded := l.labels.LoadOrStore(msgID, strconv.FormatUint(uint64(msgID), 10))
+	if loaded {
+		atomic.AddInt32(&l.count, -1)
+	}
+	return label.(string)
+}
+
+// histogramVec is a set of latency histograms keyed by msgID (按msgID区分的一组耗时直方图)
+type histogramVec struct {
+	labels     msgIDLabels
+	histograms sync.Map
+}
+
+type histogram struct {
+	buckets []uint64 // not cumulative, the last one is +Inf (非累计值，最后一个为+Inf)
+	sumNano uint64
+}
+
+func (v *histogramVec) observe(msgID uint32, d time.Duration) {
+	label := v.labels.of(msgID)
+	h, ok := v.histograms.Load(label)
+	if !ok {
+		h, _ = v.histograms.LoadOrStore(label, &histogram{buckets: make([]uint64, len(handlerBuckets)+1)})
+	}
+
+	hist := h.(*histogram)
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(handlerBuckets, seconds)
+	atomic.AddUint64(&hist.buckets[i], 1)
+	atomic.AddUint64(&hist.sumNano, uint64(d.Nanoseconds()))
+}
+
+// connAccepted counts a connection accepted on transport (统计一个被接收的连接)
+func (m *serverMetrics) connAccepted(transport string) {
+	if m == nil {
+		return
+	}
+	m.accepted.add(connLabels{transport: transport}, 1)
+}
+
+// connRejected counts a connection rejected on transport, see the reject* constants for the reasons
+// (统计一个被拒绝的连接，原因见reject*常量)
+func (m *serverMetrics) connRejected(transport string, reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.add(connLabels{transport: transport, reason: reason}, 1)
+}
+
+// connClosed counts a connection closed on transport (统计一个已关闭的连接)
+func (m *serverMetrics) connClosed(transport string) {
+	if m == nil {
+		return
+	}
+	m.closed.add(connLabels{transport: transport}, 1)
+}
+
+// msgReceived counts an inbound message and its payload bytes, call flags are ignored with CallMode (统计一条收到的消息及其数据字节数，开启CallMode时忽略调用标志)
+func (m *serverMetrics) msgReceived(msgID uint32, size int) {
+	if m == nil {
+		return
+	}
+	m.msgIn.add(msgID&m.msgIDMask, size)
+}
+
+// msgSent counts an outbound message and its payload bytes, call flags are ignored with CallMode (统计一条发送的消息及其数据字节数，开启CallMode时忽略调用标志)
+func (m *serverMetrics) msgSent(msgID uint32, size int) {
+	if m == nil {
+		return
+	}
+	m.msgOut.add(msgID&m.msgIDMask, size)
+}
+
+// observeHandler records how long the handler of a msgID took (记录某msgID处理函数的耗时)
+func (m *serverMetrics) observeHandler(msgID uint32, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.handlers.observe(msgID, d)
+}
+
+// sendQueueTimeout counts a message dropped because the send queue of a connection stayed full
+// (统计一条因连接发送队列持续已满而被丢弃的消息)
+func (m *serverMetrics) sendQueueTimeout(transport string) {
+	if m == nil {
+		return
+	}
+	m.sendTimeouts.add(connLabels{transport: transport}, 1)
+}
+
+// metricsOf returns the metrics of the server owning a msg handler, nil if there is none
+// (返回消息处理模块所属服务器的指标，没有时返回nil)
+func metricsOf(msgHandler giface.IMsgHandler) *serverMetrics {
+	if mh, ok := msgHandler.(*MsgHandler); ok {
+		return mh.metrics
+	}
+	return nil
+}
+
+// MetricsHandler returns the http.Handler serving the metrics in the Prometheus text format, it can be
+// mounted under any HTTP server. Only the gauges are served unless gconf.Config.Metrics or MetricsAddr is set.
+// (返回以Prometheus文本格式输出指标的http.Handler，可以挂载到任意HTTP服务下。除非设置了Metrics或MetricsAddr，否则只输出瞬时值)
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		s.writeMetrics(bw)
+		if err := bw.Flush(); err != nil {
+			glog.Ins().ErrorF("metrics write response err: %v", err)
+		}
+	})
+}
+
+// MetricsAddr returns the address the metrics listener is bound to, nil if it is not listening
+// (返回指标监听实际绑定的地址，未监听时为nil)
+func (s *Server) MetricsAddr() net.Addr {
+	return s.addr(metricsMode)
+}
+
+// listenMetrics serves the metrics on addr and path until the server stops
+// (在addr和path上提供指标，直到服务停止)
+func (s *Server) listenMetrics(addr string, path string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		glog.Ins().ErrorF("[START] metrics listen %s err: %v", addr, err)
+		return
+	}
+	s.addAddr(metricsMode, listener.Addr())
+	glog.Ins().InfoF("[START] metrics server name: %s, listening at %s%s", s.Name, listener.Addr(), path)
+
+	mux := http.NewServeMux()
+	mux.Handle(path, s.MetricsHandler())
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			glog.Ins().ErrorF("metrics serve err: %v", err)
+		}
+	}()
+
+	<-s.exitChan
+	_ = httpServer.Close()
+}
+
+// writeMetrics renders the counters and the gauges read at scrape time
+// (输出计数器以及抓取时读取的瞬时值)
+func (s *Server) writeMetrics(w *bufio.Writer) {
+	m := s.metrics
+
+	writeHeader(w, "gray_connections", "gauge", "Current number of connections.")
+	fmt.Fprintf(w, "gray_connections %d\n", s.ConnMgr.Len())
+
+	if mh, ok := s.msgHandler.(*MsgHandler); ok {
+		writeHeader(w, "gray_requests_pending", "gauge", "Requests dispatched to the handlers and not finished yet.")
+		fmt.Fprintf(w, "gray_requests_pending %d\n", atomic.LoadInt64(&mh.pending))
+
+		writeHeader(w, "gray_task_queue_depth", "gauge", "Requests waiting in the task queue of each worker.")
+		for i, queue := range mh.TaskQueue {
+			fmt.Fprintf(w, "gray_task_queue_depth{worker=\"%d\"} %d\n", i, len(queue))
+		}
+		writeHeader(w, "gray_task_queue_capacity", "gauge", "Capacity of the task queue of each worker.")
+		for i, queue := range mh.TaskQueue {
+			fmt.Fprintf(w, "gray_task_queue_capacity{worker=\"%d\"} %d\n", i, cap(queue))
+		}
+	}
+
+	if m == nil {
+		return
+	}
+
+	writeConnCounter(w, &m.accepted, "gray_connections_accepted_total", "Connections accepted per transport.")
+	writeConnCounter(w, &m.rejected, "gray_connections_rejected_total", "Connections rejected per transport and reason.")
+	writeConnCounter(w, &m.closed, "gray_connections_closed_total", "Connections closed per transport.")
+	writeConnCounter(w, &m.sendTimeouts, "gray_send_queue_timeouts_total", "Messages dropped because the send queue stayed full, per transport.")
+
+	writeMsgIDCounter(w, &m.msgIn.messages, "gray_messages_received_total", "Messages received per msgID.")
+	writeMsgIDCounter(w, &m.msgIn.bytes, "gray_message_bytes_received_total", "Payload bytes received per msgID.")
+	writeMsgIDCounter(w, &m.msgOut.messages, "gray_messages_sent_total", "Messages sent per msgID.")
+	writeMsgIDCounter(w, &m.msgOut.bytes, "gray_message_bytes_sent_total", "Payload bytes sent per msgID.")
+
+	writeHistograms(w, &m.handlers, "gray_handler_duration_seconds", "Time spent in the router handlers per msgID.")
+}
+
+func writeHeader(w *bufio.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeConnCounter(w *bufio.Writer, v *counterVec, name string, help string) {
+	type sample struct {
+		labels connLabels
+		value  uint64
+	}
+	var samples []sample
+	v.each(func(key interface{}, value uint64) {
+		samples = append(samples, sample{labels: key.(connLabels), value: value})
+	})
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].labels.transport != samples[j].labels.transport {
+			return samples[i].labels.transport < samples[j].labels.transport
+		}
+		return samples[i].labels.reason < samples[j].labels.reason
+	})
+
+	writeHeader(w, name, "counter", help)
+	for _, s := range samples {
+		if s.labels.reason != "" {
+			fmt.Fprintf(w, "%s{transport=%q,reason=%q} %d\n", name, s.labels.transport, s.labels.reason, s.value)
+		} else {
+			fmt.Fprintf(w, "%s{transport=%q} %d\n", name, s.labels.transport, s.value)
+		}
+	}
+}
+
+func writeMsgIDCounter(w *bufio.Writer, v *counterVec, name string, help string) {
+	values := make(map[string]uint64)
+	v.each(func(key interface{}, value uint64) {
+		values[key.(string)] = value
+	})
+
+	writeHeader(w, name, "counter", help)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	for _, msgID := range sortMsgIDs(keys) {
+		fmt.Fprintf(w, "%s{msg_id=%q} %d\n", name, msgID, values[msgID])
+	}
+}
+
+func writeHistograms(w *bufio.Writer, v *histogramVec, name string, help string) {
+	histograms := make(map[string]*histogram)
+	v.histograms.Range(func(key, h interface{}) bool {
+		histograms[key.(string)] = h.(*histogram)
+		return true
+	})
+
+	writeHeader(w, name, "histogram", help)
+	keys := make([]string, 0, len(histograms))
+	for k := range histograms {
+		keys = append(keys, k)
+	}
+	for _, msgID := range sortMsgIDs(keys) {
+		h := histograms[msgID]
+		var cumulative uint64
+		for i, bound := range handlerBuckets {
+			cumulative += atomic.LoadUint64(&h.buckets[i])
+			fmt.Fprintf(w, "%s_bucket{msg_id=%q,le=%q} %d\n", name, msgID, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		cumulative += atomic.LoadUint64(&h.buckets[len(handlerBuckets)])
+		fmt.Fprintf(w, "%s_bucket{msg_id=%q,le=\"+Inf\"} %d\n", name, msgID, cumulative)
+		sum := float64(atomic.LoadUint64(&h.sumNano)) / float64(time.Second)
+		fmt.Fprintf(w, "%s_sum{msg_id=%q} %s\n", name, msgID, strconv.FormatFloat(sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{msg_id=%q} %d\n", name, msgID, cumulative)
+	}
+}
+
+// sortedMsgIDs sorts the msgID labels numerically, "other" last (按数值排序msgID标签，"other"排在最后)
+func sortMsgIDs(keys []string) []string {
+	sort.Slice(keys, func(i, j int) bool {
+		return msgIDOrder(keys[i]) < msgIDOrder(keys[j])
+	})
+	return keys
+}
+
+func msgIDOrder(label string) uint64 {
+	if n, err := strconv.ParseUint(label, 10, 32); err == nil {
+		return n
+	}
+	return math.MaxUint64
+}
@@ -1,13 +1,17 @@
 package gnet
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/liyee/gray/gconf"
 	"github.com/liyee/gray/giface"
 	"github.com/liyee/gray/glog"
+	"github.com/liyee/gray/gpack"
 )
 
 const (
@@ -17,6 +21,8 @@ const (
 type MsgHandler struct {
 	Apis map[uint32]giface.IRouter //存放每个MsgID 所对应的处理方法的map属性
 
+	config *gconf.Config //所属Server或Client的配置
+
 	WorkerPoolSize uint32 //业务工作Worker池的数量
 
 	freeWorkers  map[uint32]struct{} //空闲worker集合，用于gconf.WorkerModeBind
@@ -24,33 +30,44 @@ type MsgHandler struct {
 
 	TaskQueue []chan giface.IRequest //Worker负责取任务的消息队列
 
+	pending  int64 //已分发但尚未处理完的请求数量
+	draining int32 //是否处于优雅关闭的排空状态，排空时不再分发新请求
+
 	// (责任链构造器)
 	builder      *chainBuilder
 	RouterSlices *RouterSlices
+
+	metrics *serverMetrics //所属Server的指标，客户端或未开启时为nil
+
+	timeouts map[uint32]time.Duration //各MsgID处理函数的截止时间，覆盖配置中的HandlerTimeout
 }
 
-func newMsgHandler() *MsgHandler {
+func newMsgHandler(config *gconf.Config) *MsgHandler {
+	workerPoolSize := config.WorkerPoolSize
+
 	var freeWorkers map[uint32]struct{}
-	if gconf.GlobalObject.WorkerMode == gconf.WorkerModeBind {
+	if config.WorkerMode == gconf.WorkerModeBind {
 		// Assign a workder to each link, avoid interactions when multiple links are processed by the same worker
 		// MaxWorkerTaskLen can also be reduced, for example, 50
 		// 为每个链接分配一个workder，避免同一worker处理多个链接时的互相影响
 		// 同时可以减小MaxWorkerTaskLen，比如50，因为每个worker的负担减轻了
-		gconf.GlobalObject.WorkerPoolSize = uint32(gconf.GlobalObject.MaxConn)
-		freeWorkers = make(map[uint32]struct{}, gconf.GlobalObject.WorkerPoolSize)
-		for i := uint32(0); i < gconf.GlobalObject.WorkerPoolSize; i++ {
+		workerPoolSize = uint32(config.MaxConn)
+		freeWorkers = make(map[uint32]struct{}, workerPoolSize)
+		for i := uint32(0); i < workerPoolSize; i++ {
 			freeWorkers[i] = struct{}{}
 		}
 	}
 
 	handler := &MsgHandler{
 		Apis:           make(map[uint32]giface.IRouter),
+		config:         config,
 		RouterSlices:   NewRouterSlices(),
-		WorkerPoolSize: gconf.GlobalObject.WorkerPoolSize,
+		WorkerPoolSize: workerPoolSize,
 		// One worker corresponds to one queue (一个worker对应一个queue)
-		TaskQueue:   make([]chan giface.IRequest, gconf.GlobalObject.WorkerPoolSize),
+		TaskQueue:   make([]chan giface.IRequest, workerPoolSize),
 		freeWorkers: freeWorkers,
 		builder:     newChainBuilder(),
+		timeouts:    make(map[uint32]time.Duration),
 	}
 
 	// It is necessary to add the MsgHandle to the responsibility chain here, and it is the last link in the responsibility chain. After decoding in the MsgHandle, data distribution is done by router
@@ -67,7 +84,7 @@ func useWorker(conn giface.IConnection) uint32 {
 		return 0
 	}
 
-	if gconf.GlobalObject.WorkerMode == gconf.WorkerModeBind {
+	if mh.config.WorkerMode == gconf.WorkerModeBind {
 		mh.freeWorkerMu.Lock()
 		defer mh.freeWorkerMu.Unlock()
 
@@ -96,7 +113,7 @@ func freeWorker(conn giface.IConnection) {
 		return
 	}
 
-	if gconf.GlobalObject.WorkerMode == gconf.WorkerModeBind {
+	if mh.config.WorkerMode == gconf.WorkerModeBind {
 		mh.freeWorkerMu.Lock()
 		defer mh.freeWorkerMu.Unlock()
 
@@ -110,19 +127,40 @@ func (mh *MsgHandler) Intercept(chain giface.IChain) giface.IcResp {
 		switch request.(type) {
 		case giface.IRequest:
 			iRequest := request.(giface.IRequest)
-			if gconf.GlobalObject.WorkerPoolSize > 0 {
+			dispatch := true
+			if mh.config.CallMode {
+				dispatch = routeCall(iRequest)
+			}
+			mh.metrics.msgReceived(iRequest.GetMsgID(), len(iRequest.GetData()))
+			if !dispatch {
+				// A reply is handed to the pending call instead of the routers
+				// (应答交给等待中的调用，而不是路由)
+				break
+			}
+			if !mh.acquire() {
+				// The server is shutting down, the requests read meanwhile are rejected and the connection is closed
+				// once its send queue is flushed, so the peer learns that the request was not handled
+				// (服务正在关闭，期间读到的请求被拒绝，连接在发送队列清空后关闭，对端由此得知请求未被处理)
+				glog.Ins().ErrorF("msg handler is draining, reject ConnID=%d msgID = %d",
+					iRequest.GetConnection().GetConnID(), iRequest.GetMsgID())
+				break
+			}
+			if mh.WorkerPoolSize > 0 {
 				// If the worker pool mechanism has been started, hand over the message to the worker for processing
 				// (已经启动工作池机制，将消息交给Worker处理)
-				mh.SendMsgToTaskQueue(iRequest)
+				mh.enqueue(iRequest)
 			} else {
 
 				// Execute the corresponding Handle method from the bound message and its corresponding processing method
 				// (从绑定好的消息和对应的处理方法中执行对应的Handle方法)
-				if !gconf.GlobalObject.RouterSlicesMode {
-					go mh.doMsgHandler(iRequest, WorkerIDWithoutWorkerPool)
-				} else if gconf.GlobalObject.RouterSlicesMode {
-					go mh.doMsgHandlerSlices(iRequest, WorkerIDWithoutWorkerPool)
-				}
+				go func() {
+					defer atomic.AddInt64(&mh.pending, -1)
+					if !mh.config.RouterSlicesMode {
+						mh.doMsgHandler(iRequest, WorkerIDWithoutWorkerPool)
+					} else if mh.config.RouterSlicesMode {
+						mh.doMsgHandlerSlices(iRequest, WorkerIDWithoutWorkerPool)
+					}
+				}()
 
 			}
 		}
@@ -145,6 +183,25 @@ func (mh *MsgHandler) AddInterceptor(interceptor giface.IInterceptor) {
 // SendMsgToTaskQueue sends the message to the TaskQueue for processing by the worker
 // (将消息交给TaskQueue,由worker进行处理)
 func (mh *MsgHandler) SendMsgToTaskQueue(request giface.IRequest) {
+	atomic.AddInt64(&mh.pending, 1)
+	mh.enqueue(request)
+}
+
+// acquire counts a request as pending unless the handler is draining. The request is counted before draining
+// is checked, so either Drain waits for it or it sees draining and backs out.
+// (在未排空时将请求计入pending。先计数再检查draining，因此要么Drain等待该请求，要么该请求发现正在排空并撤回计数)
+func (mh *MsgHandler) acquire() bool {
+	atomic.AddInt64(&mh.pending, 1)
+	if atomic.LoadInt32(&mh.draining) == 1 {
+		atomic.AddInt64(&mh.pending, -1)
+		return false
+	}
+	return true
+}
+
+// enqueue sends a request already counted as pending to the TaskQueue of its worker
+// (将已计入pending的请求发送到其worker的TaskQueue)
+func (mh *MsgHandler) enqueue(request giface.IRequest) {
 	workerID := request.GetConnection().GetWorkerID()
 	// glog.Ins().DebugF("Add ConnID=%d request msgID=%d to workerID=%d", request.GetConnection().GetConnID(), request.GetMsgID(), workerID)
 	// Send the request message to the task queue
@@ -164,6 +221,9 @@ func (mh *MsgHandler) doMsgHandler(request giface.IRequest, workerID int) {
 	defer func() {
 		if err := recover(); err != nil {
 			glog.Ins().ErrorF("workerID: %d doMsgHandler panic: %v", workerID, err)
+			// The request is not put back to the pool after a panic, so its context can be cancelled here
+			// (panic后请求不会放回对象池，因此可以在这里取消其context)
+			finishRequest(request)
 		}
 	}()
 
@@ -172,19 +232,72 @@ func (mh *MsgHandler) doMsgHandler(request giface.IRequest, workerID int) {
 
 	if !ok {
 		glog.Ins().ErrorF("api msgID = %d is not FOUND!", request.GetMsgID())
+		// An interceptor may have created the context already (拦截器可能已经创建了context)
+		finishRequest(request)
+		PutRequest(request)
 		return
 	}
 
+	// The request is put back to the pool at the end, so msgId is taken first
+	// (请求最后会放回对象池，因此先取出msgId)
+	defer mh.observeHandler(msgId, time.Now())
+
 	// Bind the Request request to the corresponding Router relationship
 	// (Request请求绑定Router对应关系)
 	request.BindRouter(handler)
+	mh.startRequest(request, msgId)
 
 	// Execute the corresponding processing method
 	request.Call()
 
 	// 执行完成后回收 Request 对象回对象池
+	finishRequest(request)
 	PutRequest(request)
 }
+
+// observeHandler records the latency of the handler of msgId that started at start
+// (记录从start开始的msgId处理函数耗时)
+func (mh *MsgHandler) observeHandler(msgId uint32, start time.Time) {
+	mh.metrics.observeHandler(msgId, time.Since(start))
+}
+
+// SetHandlerTimeout sets the deadline of the request context in the handlers of msgID, overriding
+// gconf.Config.HandlerTimeout, a negative timeout removes the deadline. It must be called before Start.
+// (设置msgID处理函数中请求context的截止时间，覆盖gconf.Config.HandlerTimeout，负数表示不限制，需要在Start之前调用)
+func (mh *MsgHandler) SetHandlerTimeout(msgID uint32, timeout time.Duration) {
+	mh.timeouts[msgID] = timeout
+}
+
+// handlerTimeout returns the deadline of the handlers of msgId, 0 means none (返回msgId处理函数的截止时间，0表示不限制)
+func (mh *MsgHandler) handlerTimeout(msgId uint32) time.Duration {
+	timeout, ok := mh.timeouts[msgId]
+	if !ok {
+		timeout = mh.config.HandlerTimeoutDuration()
+	}
+	if timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// startRequest sets the handler deadline on the context of the request before the handler runs
+// (在处理函数执行前为请求的context设置截止时间)
+func (mh *MsgHandler) startRequest(request giface.IRequest, msgId uint32) {
+	if req, ok := request.(*Request); ok {
+		if timeout := mh.handlerTimeout(msgId); timeout > 0 {
+			req.setDeadline(time.Now().Add(timeout))
+		}
+	}
+}
+
+// finishRequest cancels the context of the request once the handler returns, before the request is put back to the pool
+// (处理函数返回后、请求放回对象池之前取消请求的context)
+func finishRequest(request giface.IRequest) {
+	if req, ok := request.(*Request); ok {
+		req.done()
+	}
+}
+
 func (mh *MsgHandler) Execute(request giface.IRequest) {
 	// Pass the message to the responsibility chain to handle it through interceptors layer by layer and pass it on layer by layer.
 	// (将消息丢到责任链，通过责任链里拦截器层层处理层层传递)
@@ -194,6 +307,7 @@ func (mh *MsgHandler) Execute(request giface.IRequest) {
 // AddRouter adds specific processing logic for messages
 // (为消息添加具体的处理逻辑)
 func (mh *MsgHandler) AddRouter(msgID uint32, router giface.IRouter) {
+	mh.checkMsgID(msgID)
 	// 1. Check whether the current API processing method bound to the msgID already exists
 	// (判断当前msg绑定的API处理方法是否已经存在)
 	if _, ok := mh.Apis[msgID]; ok {
@@ -206,14 +320,25 @@ func (mh *MsgHandler) AddRouter(msgID uint32, router giface.IRouter) {
 	glog.Ins().InfoF("Add Router msgID = %d", msgID)
 }
 func (mh *MsgHandler) AddRouterSlices(msgId uint32, handler ...giface.RouterHandler) giface.IRouterSlices {
+	mh.checkMsgID(msgId)
 	mh.RouterSlices.AddHandler(msgId, handler...)
 	return mh.RouterSlices
 }
 
 // Group routes into a group (路由分组)
 func (mh *MsgHandler) Group(start, end uint32, Handlers ...giface.RouterHandler) giface.IGroupRouterSlices {
+	mh.checkMsgID(end)
 	return NewGroup(start, end, mh.RouterSlices, Handlers...)
 }
+
+// checkMsgID panics if msgID overlaps the call flags reserved by CallMode, such a router could never be reached
+// (msgID与CallMode保留的调用标志位重叠时panic，这样的路由永远不会被调用)
+func (mh *MsgHandler) checkMsgID(msgID uint32) {
+	if mh.config.CallMode && msgID > gpack.MsgIDMask {
+		panic(fmt.Sprintf("msgID = %d overlaps the call flags reserved by CallMode, the max is %d", msgID, gpack.MsgIDMask))
+	}
+}
+
 func (mh *MsgHandler) Use(Handlers ...giface.RouterHandler) giface.IRouterSlices {
 	mh.RouterSlices.Use(Handlers...)
 	return mh.RouterSlices
@@ -223,6 +348,9 @@ func (mh *MsgHandler) doMsgHandlerSlices(request giface.IRequest, workerID int)
 	defer func() {
 		if err := recover(); err != nil {
 			glog.Ins().ErrorF("workerID: %d doMsgHandler panic: %v", workerID, err)
+			// The request is not put back to the pool after a panic, so its context can be cancelled here
+			// (panic后请求不会放回对象池，因此可以在这里取消其context)
+			finishRequest(request)
 		}
 	}()
 
@@ -230,12 +358,21 @@ func (mh *MsgHandler) doMsgHandlerSlices(request giface.IRequest, workerID int)
 	handlers, ok := mh.RouterSlices.GetHandlers(msgId)
 	if !ok {
 		glog.Ins().ErrorF("api msgID = %d is not FOUND!", request.GetMsgID())
+		// An interceptor may have created the context already (拦截器可能已经创建了context)
+		finishRequest(request)
+		PutRequest(request)
 		return
 	}
 
+	// The request is put back to the pool at the end, so msgId is taken first
+	// (请求最后会放回对象池，因此先取出msgId)
+	defer mh.observeHandler(msgId, time.Now())
+
 	request.BindRouterSlices(handlers)
+	mh.startRequest(request, msgId)
 	request.RouterSlicesNext()
 	// 执行完成后回收 Request 对象回对象池
+	finishRequest(request)
 	PutRequest(request)
 }
 
@@ -258,16 +395,28 @@ func (mh *MsgHandler) StartOneWorker(workerID int, taskQueue chan giface.IReques
 
 			case giface.IRequest: // Client message request
 
-				if !gconf.GlobalObject.RouterSlicesMode {
+				if !mh.config.RouterSlicesMode {
 					mh.doMsgHandler(req, workerID)
-				} else if gconf.GlobalObject.RouterSlicesMode {
+				} else if mh.config.RouterSlicesMode {
 					mh.doMsgHandlerSlices(req, workerID)
 				}
 			}
+			atomic.AddInt64(&mh.pending, -1)
 		}
 	}
 }
 
+// Drain stops dispatching new requests and waits until all the dispatched requests have been handled,
+// the requests read after Drain is called are rejected with an error log
+// (停止分发新请求，并等待已分发的请求全部处理完毕，调用Drain之后读到的请求会被拒绝并记录错误日志)
+func (mh *MsgHandler) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&mh.draining, 1)
+
+	return waitUntil(ctx, func() bool {
+		return atomic.LoadInt64(&mh.pending) <= 0
+	})
+}
+
 func (mh *MsgHandler) StartWorkerPool() {
 	// Iterate through the required number of workers and start them one by one
 	// (遍历需要启动worker的数量，依此启动)
@@ -275,7 +424,7 @@ func (mh *MsgHandler) StartWorkerPool() {
 		// A worker is started
 		// Allocate space for the corresponding task queue for the current worker
 		// (给当前worker对应的任务队列开辟空间)
-		mh.TaskQueue[i] = make(chan giface.IRequest, gconf.GlobalObject.MaxWorkerTaskLen)
+		mh.TaskQueue[i] = make(chan giface.IRequest, mh.config.MaxWorkerTaskLen)
 
 		// Start the current worker, blocking and waiting for messages to be passed in the corresponding task queue
 		// (启动当前Worker，阻塞的等待对应的任务队列是否有消息传递进来)
@@ -1,6 +1,9 @@
 package gnet
 
-import "github.com/liyee/gray/giface"
+import (
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+)
 
 type Option func(s *Server)
 
@@ -10,6 +13,13 @@ func WithPacket(pack giface.IDataPack) Option {
 	}
 }
 
+// Set the connection ID generator of the server, see IServer.SetConnIDGenerator
+func WithConnIDGenerator(gen giface.IConnIDGenerator) Option {
+	return func(s *Server) {
+		s.SetConnIDGenerator(gen)
+	}
+}
+
 // Options for Client
 type ClientOption func(c giface.IClient)
 
@@ -27,3 +37,75 @@ func WithNameClient(name string) ClientOption {
 		c.SetName(name)
 	}
 }
+
+// Make the websocket client dial wss://, caFile is the PEM CA bundle used to verify the server
+// (system roots if empty), serverName overrides the SNI and verified host name (server address if empty)
+func WithWssClient(caFile string, serverName string) ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.useTLS = true
+			client.tlsCAFile = caFile
+			client.tlsServerName = serverName
+		}
+	}
+}
+
+// Set the client certificate and private key presented to servers requiring mutual TLS,
+// it applies to TLS and wss clients
+func WithTLSCertClient(certFile string, keyFile string) ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.tlsCertFile = certFile
+			client.tlsKeyFile = keyFile
+		}
+	}
+}
+
+// Set the KCP options of a KCP client, nodelay, windows and FEC shards should match the server
+func WithKcpConfigClient(config *KcpConfig) ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok && config != nil {
+			client.kcpConfig = config
+		}
+	}
+}
+
+// Set the socket options of a TCP or websocket client, by default they are taken from the config
+func WithTcpConfigClient(config *TcpConfig) ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok && config != nil {
+			client.tcpConfig = config
+		}
+	}
+}
+
+// Keep the session token sent by a server with SessionGracePeriod set, and present it when the client
+// connects again (e.g. after Restart) to resume the session with its properties and buffered messages
+func WithSessionResumeClient() ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.session = &clientSession{}
+		}
+	}
+}
+
+// Make the client reconnect with exponential backoff and jitter when its connection closes or dialing fails,
+// nil uses the defaults of ReconnectConfig
+func WithReconnectClient(config *ReconnectConfig) ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.reconnect = newReconnectConfig(config)
+		}
+	}
+}
+
+// Give the client its own config, the non-zero fields of config override a copy of the global config
+// and the worker pool stays turned off. The msg handler, the default packet, the KCP and TCP options are
+// built from it unless set by other options, whatever their order
+func WithConfigClient(config *gconf.Config) ClientOption {
+	return func(c giface.IClient) {
+		if client, ok := c.(*Client); ok && config != nil {
+			client.config = newClientConfig(gconf.NewUserConf(config))
+		}
+	}
+}
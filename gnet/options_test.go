@@ -0,0 +1,60 @@
+package gnet
+
+import (
+	"testing"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/gpack"
+)
+
+func TestWithConfigClientOrder(t *testing.T) {
+	config := &gconf.Config{MaxPacketSize: 1234}
+	packet := gpack.NewDataPackLtv()
+	kcpConfig := &KcpConfig{KcpSendWindow: 7}
+
+	tests := []struct {
+		name string
+		opts []ClientOption
+	}{
+		{name: "config first", opts: []ClientOption{WithConfigClient(config), WithPacketClient(packet), WithKcpConfigClient(kcpConfig)}},
+		{name: "config last", opts: []ClientOption{WithPacketClient(packet), WithKcpConfigClient(kcpConfig), WithConfigClient(config)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewKcpClient("127.0.0.1", 0, tt.opts...).(*Client)
+
+			if got := c.GetConfig().MaxPacketSize; got != config.MaxPacketSize {
+				t.Errorf("MaxPacketSize = %d, want %d", got, config.MaxPacketSize)
+			}
+			if c.GetPacket() != giface.IDataPack(packet) {
+				t.Error("the packet set by WithPacketClient was replaced")
+			}
+			if c.kcpConfig != kcpConfig {
+				t.Error("the KCP config set by WithKcpConfigClient was replaced")
+			}
+		})
+	}
+}
+
+func TestWithConfigClientDerivesDefaults(t *testing.T) {
+	c := NewClient("127.0.0.1", 0, WithNameClient("c"), WithConfigClient(&gconf.Config{TcpNoDelay: -1})).(*Client)
+
+	if c.tcpConfig == nil || c.tcpConfig.TcpNoDelay != -1 {
+		t.Errorf("tcpConfig = %+v, want TcpNoDelay from the client config", c.tcpConfig)
+	}
+	if c.GetMsgHandler() == nil || c.GetPacket() == nil {
+		t.Error("msg handler and packet are not built")
+	}
+	if c.GetConfig().WorkerPoolSize != 0 {
+		t.Errorf("WorkerPoolSize = %d, want 0", c.GetConfig().WorkerPoolSize)
+	}
+}
+
+func TestNewServerDoesNotShareGlobalConfig(t *testing.T) {
+	s := NewServer().(*Server)
+	if s.config == gconf.GlobalObject {
+		t.Fatal("NewServer uses gconf.GlobalObject instead of a copy")
+	}
+}
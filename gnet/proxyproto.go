@@ -0,0 +1,240 @@
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The longest PROXY protocol v1 header, including the CRLF (PROXY protocol v1头部的最大长度，包含CRLF)
+	proxyV1MaxLen = 107
+	// The length of the fixed part of a PROXY protocol v2 header (PROXY protocol v2头部固定部分的长度)
+	proxyV2HeaderLen = 16
+	// The default timeout of reading the header (读取头部的默认超时时间)
+	defaultProxyHeaderTimeout = 5 * time.Second
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	errProxyHeaderMissing = errors.New("proxy protocol: header missing")
+)
+
+// proxyProtoListener wraps a stream listener whose connections start with a PROXY protocol header
+// (包装一个流式监听，其连接以PROXY protocol头部开始)
+type proxyProtoListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func newProxyProtoListener(listener net.Listener, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyProtoListener{Listener: listener, timeout: timeout}
+}
+
+// Accept returns the connection without reading the header, so a slow peer can not block the accept loop.
+// The header is read by readHeader or on the first Read, RemoteAddr or LocalAddr.
+// (Accept不读取头部，避免慢速对端阻塞Accept循环，头部在readHeader或首次Read、RemoteAddr、LocalAddr时读取)
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyProtoConn exposes the source and destination addresses carried by the PROXY protocol header
+// (通过PROXY protocol头部携带的源地址和目的地址对外提供RemoteAddr和LocalAddr)
+type proxyProtoConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// readHeader reads and parses the header once, within the timeout
+// (在超时时间内读取并解析头部，只执行一次)
+func (c *proxyProtoConn) readHeader() error {
+	c.once.Do(func() {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			c.err = err
+			return
+		}
+		c.remoteAddr, c.localAddr, c.err = parseProxyHeader(c.reader)
+		if err := c.Conn.SetReadDeadline(time.Time{}); err != nil && c.err == nil {
+			c.err = err
+		}
+	})
+	return c.err
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address of the header, or the address of the peer if the header
+// carries no address (LOCAL command, UNKNOWN family) or could not be read
+// (返回头部中的源地址，头部不含地址(LOCAL命令、UNKNOWN协议族)或读取失败时返回对端地址)
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the header, or the local address of the socket
+// (返回头部中的目的地址，否则返回socket的本地地址)
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// asProxyProtoConn finds the proxyProtoConn under conn, looking through a TLS layer
+// (查找conn下层的proxyProtoConn，会穿过TLS层)
+func asProxyProtoConn(conn net.Conn) (*proxyProtoConn, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	pc, ok := conn.(*proxyProtoConn)
+	return pc, ok
+}
+
+// parseProxyHeader reads a PROXY protocol v1 or v2 header, nil addresses mean the peer address should be used
+// (读取PROXY protocol v1或v2头部，返回的地址为nil时表示应使用对端地址)
+func parseProxyHeader(r *bufio.Reader) (remote net.Addr, local net.Addr, err error) {
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: read header: %w", err)
+	}
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		return parseProxyV1(r)
+	}
+
+	signature, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: read header: %w", err)
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		return parseProxyV2(r)
+	}
+
+	return nil, nil, errProxyHeaderMissing
+}
+
+// parseProxyV1 parses "PROXY TCP4|TCP6|UNKNOWN <src ip> <dst ip> <src port> <dst port>\r\n"
+func parseProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxy protocol: read v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("proxy protocol: v1 header too long or not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxy protocol: invalid v1 header %q", line)
+	}
+
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ip string, port string) (net.Addr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("proxy protocol: invalid v1 address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: invalid v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// parseProxyV2 parses the binary header: signature(12) ver_cmd(1) fam(1) len(2) addresses(len)
+func parseProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: read v2 header: %w", err)
+	}
+
+	verCmd, fam := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported v2 version %d", verCmd>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: read v2 addresses: %w", err)
+	}
+
+	switch verCmd & 0x0F {
+	case 0x00:
+		// LOCAL: health checks of the proxy itself, keep the peer address (代理自身的健康检查，使用对端地址)
+		return nil, nil, nil
+	case 0x01:
+		// PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported v2 command %d", verCmd&0x0F)
+	}
+
+	switch fam >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, nil, errors.New("proxy protocol: short v2 IPv4 addresses")
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		return src, dst, nil
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, nil, errors.New("proxy protocol: short v2 IPv6 addresses")
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		return src, dst, nil
+	default:
+		// AF_UNSPEC or AF_UNIX, keep the peer address (未指定或unix协议族，使用对端地址)
+		return nil, nil, nil
+	}
+}
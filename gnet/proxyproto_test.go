@@ -0,0 +1,262 @@
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a PROXY protocol v2 header, length is the value of the length field
+// (构建PROXY protocol v2头部，length为长度字段的值)
+func proxyV2(verCmd byte, fam byte, length int, addrs []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(length))
+	return append(header, addrs...)
+}
+
+func proxyV2IPv4Addrs() []byte {
+	addrs := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(addrs[8:10], 51000)
+	binary.BigEndian.PutUint16(addrs[10:12], 443)
+	return addrs
+}
+
+func proxyV2IPv6Addrs() []byte {
+	addrs := make([]byte, 36)
+	copy(addrs[0:16], net.ParseIP("2001:db8::1"))
+	copy(addrs[16:32], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(addrs[32:34], 51000)
+	binary.BigEndian.PutUint16(addrs[34:36], 443)
+	return addrs
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	ipv4 := proxyV2IPv4Addrs()
+	ipv6 := proxyV2IPv6Addrs()
+
+	tests := []struct {
+		name       string
+		input      []byte
+		wantRemote string // "" means the peer address is kept (""表示使用对端地址)
+		wantLocal  string
+		wantErr    string
+	}{
+		// v1
+		{
+			name:       "v1 tcp4",
+			input:      []byte("PROXY TCP4 192.0.2.1 198.51.100.1 51000 443\r\n"),
+			wantRemote: "192.0.2.1:51000",
+			wantLocal:  "198.51.100.1:443",
+		},
+		{
+			name:       "v1 tcp6",
+			input:      []byte("PROXY TCP6 2001:db8::1 2001:db8::2 51000 443\r\n"),
+			wantRemote: "[2001:db8::1]:51000",
+			wantLocal:  "[2001:db8::2]:443",
+		},
+		{
+			name:  "v1 unknown",
+			input: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:  "v1 unknown with addresses",
+			input: []byte("PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n"),
+		},
+		{
+			name:    "v1 missing CRLF",
+			input:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 51000 443\n"),
+			wantErr: "not terminated by CRLF",
+		},
+		{
+			name:    "v1 truncated",
+			input:   []byte("PROXY TCP4 192.0.2.1 198.51"),
+			wantErr: "read v1 header",
+		},
+		{
+			name:    "v1 too long",
+			input:   []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLen) + "\r\n"),
+			wantErr: "too long",
+		},
+		{
+			name:    "v1 wrong field count",
+			input:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 51000\r\n"),
+			wantErr: "invalid v1 header",
+		},
+		{
+			name:    "v1 unknown protocol",
+			input:   []byte("PROXY UDP4 192.0.2.1 198.51.100.1 51000 443\r\n"),
+			wantErr: "invalid v1 header",
+		},
+		{
+			name:    "v1 invalid address",
+			input:   []byte("PROXY TCP4 192.0.2.x 198.51.100.1 51000 443\r\n"),
+			wantErr: "invalid v1 address",
+		},
+		{
+			name:    "v1 invalid port",
+			input:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 51000 65536\r\n"),
+			wantErr: "invalid v1 port",
+		},
+		// v2
+		{
+			name:       "v2 ipv4",
+			input:      proxyV2(0x21, 0x11, len(ipv4), ipv4),
+			wantRemote: "192.0.2.1:51000",
+			wantLocal:  "198.51.100.1:443",
+		},
+		{
+			name:       "v2 ipv6",
+			input:      proxyV2(0x21, 0x21, len(ipv6), ipv6),
+			wantRemote: "[2001:db8::1]:51000",
+			wantLocal:  "[2001:db8::2]:443",
+		},
+		{
+			name:       "v2 ipv4 with TLVs",
+			input:      proxyV2(0x21, 0x11, len(ipv4)+4, append(append([]byte(nil), ipv4...), 0x04, 0x00, 0x01, 0xFF)),
+			wantRemote: "192.0.2.1:51000",
+			wantLocal:  "198.51.100.1:443",
+		},
+		{
+			name:  "v2 local",
+			input: proxyV2(0x20, 0x00, 0, nil),
+		},
+		{
+			name:  "v2 unspec family",
+			input: proxyV2(0x21, 0x00, 0, nil),
+		},
+		{
+			name:    "v2 truncated fixed header",
+			input:   append(append([]byte(nil), proxyV2Signature...), 0x21),
+			wantErr: "read v2 header",
+		},
+		{
+			name:    "v2 truncated signature",
+			input:   proxyV2Signature[:8],
+			wantErr: "read header",
+		},
+		{
+			name:    "v2 truncated addresses",
+			input:   proxyV2(0x21, 0x11, len(ipv4), ipv4[:6]),
+			wantErr: "read v2 addresses",
+		},
+		{
+			name:    "v2 short ipv4 addresses",
+			input:   proxyV2(0x21, 0x11, 6, ipv4[:6]),
+			wantErr: "short v2 IPv4 addresses",
+		},
+		{
+			name:    "v2 short ipv6 addresses",
+			input:   proxyV2(0x21, 0x21, len(ipv4), ipv4),
+			wantErr: "short v2 IPv6 addresses",
+		},
+		{
+			name:    "v2 bad version",
+			input:   proxyV2(0x11, 0x11, len(ipv4), ipv4),
+			wantErr: "unsupported v2 version",
+		},
+		{
+			name:    "v2 bad command",
+			input:   proxyV2(0x22, 0x11, len(ipv4), ipv4),
+			wantErr: "unsupported v2 command",
+		},
+		// neither
+		{
+			name:    "no header",
+			input:   []byte("GET / HTTP/1.1\r\n\r\n"),
+			wantErr: errProxyHeaderMissing.Error(),
+		},
+		{
+			name:    "empty",
+			input:   nil,
+			wantErr: "read header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const body = "payload"
+			r := bufio.NewReader(bytes.NewReader(append(append([]byte(nil), tt.input...), body...)))
+			if tt.wantErr != "" {
+				// Without the payload, so the truncated cases really end early (不带负载，使截断的用例确实提前结束)
+				r = bufio.NewReader(bytes.NewReader(tt.input))
+			}
+
+			remote, local, err := parseProxyHeader(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseProxyHeader() err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseProxyHeader() err = %v", err)
+			}
+			if got := addrString(remote); got != tt.wantRemote {
+				t.Errorf("remote = %q, want %q", got, tt.wantRemote)
+			}
+			if got := addrString(local); got != tt.wantLocal {
+				t.Errorf("local = %q, want %q", got, tt.wantLocal)
+			}
+
+			// The data after the header is left to the connection (头部之后的数据留给连接读取)
+			rest, _ := io.ReadAll(r)
+			if string(rest) != body {
+				t.Errorf("data after header = %q, want %q", rest, body)
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestProxyProtoConnAddrs(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 51000 443\r\nhello"))
+	}()
+
+	conn := &proxyProtoConn{Conn: server, reader: bufio.NewReader(server), timeout: defaultProxyHeaderTimeout}
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:51000" {
+		t.Errorf("RemoteAddr() = %q, want %q", got, "192.0.2.1:51000")
+	}
+	if got := conn.LocalAddr().String(); got != "198.51.100.1:443" {
+		t.Errorf("LocalAddr() = %q, want %q", got, "198.51.100.1:443")
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read() = %q, %v, want %q", buf, err, "hello")
+	}
+}
+
+func TestProxyProtoConnInvalidHeader(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("hello, no header"))
+		_ = client.Close()
+	}()
+
+	conn := &proxyProtoConn{Conn: server, reader: bufio.NewReader(server), timeout: defaultProxyHeaderTimeout}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read() err = nil, want the header error")
+	}
+	// The peer address is kept when the header is invalid (头部无效时使用对端地址)
+	if got, want := conn.RemoteAddr(), server.RemoteAddr(); got != want {
+		t.Errorf("RemoteAddr() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package gnet
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/liyee/gray/giface"
+)
+
+// ReconnectConfig makes a client reconnect with exponential backoff when its connection closes or dialing fails,
+// the routers, the heartbeat checker and the OnConnStart hook apply to every new connection
+// (客户端的连接关闭或拨号失败时按指数退避重连，路由、心跳检测器和OnConnStart钩子作用于每个新连接)
+type ReconnectConfig struct {
+	MinDelay    time.Duration // The delay before the first reconnection, default 500ms (首次重连前的延迟，默认500ms)
+	MaxDelay    time.Duration // The cap of the delay, default 30s (延迟的上限，默认30s)
+	Multiplier  float64       // The growth of the delay after each failed dial, default 2 (每次拨号失败后延迟的增长倍数，默认2)
+	Jitter      float64       // The random fraction in [0, 1] taken off each delay, default 0.2 (每次延迟随机减少的比例，取值[0, 1]，默认0.2)
+	MaxAttempts int           // The consecutive failed dials before giving up, 0 means unlimited (放弃前连续拨号失败的次数，0表示不限制)
+
+	// OnDisconnect is called when a connection closes and the client is going to reconnect
+	// (连接关闭且客户端即将重连时调用)
+	OnDisconnect func(conn giface.IConnection)
+	// OnReconnect is called after the OnConnStart hook of every connection but the first one, attempts is the number
+	// of dials it took
+	// (除第一个连接外，每个连接的OnConnStart钩子之后调用，attempts为本次连接的拨号次数)
+	OnReconnect func(conn giface.IConnection, attempts int)
+}
+
+func newReconnectConfig(config *ReconnectConfig) *ReconnectConfig {
+	r := ReconnectConfig{}
+	if config != nil {
+		r = *config
+	}
+	if r.MinDelay <= 0 {
+		r.MinDelay = 500 * time.Millisecond
+	}
+	if r.MaxDelay < r.MinDelay {
+		r.MaxDelay = 30 * time.Second
+		if r.MaxDelay < r.MinDelay {
+			r.MaxDelay = r.MinDelay
+		}
+	}
+	if r.Multiplier < 1 {
+		r.Multiplier = 2
+	}
+	if r.Jitter <= 0 || r.Jitter > 1 {
+		r.Jitter = 0.2
+	}
+	return &r
+}
+
+// delay returns the delay before the next dial after failures consecutive failed dials
+// (返回连续failures次拨号失败后下一次拨号前的延迟)
+func (r *ReconnectConfig) delay(failures int) time.Duration {
+	d := float64(r.MinDelay) * math.Pow(r.Multiplier, float64(failures))
+	if d > float64(r.MaxDelay) {
+		d = float64(r.MaxDelay)
+	}
+	d -= d * r.Jitter * rand.Float64()
+	return time.Duration(d)
+}
+
+// waitOrExit waits for d, it returns false if exitChan is closed first (等待d，若exitChan先关闭则返回false)
+func waitOrExit(exitChan chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-exitChan:
+		return false
+	}
+}
+
+// closeUnstarted closes the socket of a connection that has not been started (关闭尚未启动的连接的socket)
+func closeUnstarted(conn giface.IConnection) {
+	if wsConn := conn.GetWsConn(); wsConn != nil {
+		_ = wsConn.Close()
+	} else if netConn := conn.GetConnection(); netConn != nil {
+		_ = netConn.Close()
+	}
+}
+
+// onClientConnStartOf returns the hook run when a connection of client starts. For a Client it stops a connection
+// whose client was stopped or restarted while dialing, and runs the OnReconnect callback after the OnConnStart hook.
+// (返回client的连接建立时执行的钩子。对于Client，拨号期间客户端已停止或重启时停止该连接，并在OnConnStart钩子之后执行OnReconnect回调)
+func onClientConnStartOf(client giface.IClient) func(giface.IConnection) {
+	c, ok := client.(*Client)
+	if !ok {
+		return client.GetOnConnStart()
+	}
+
+	c.lock.Lock()
+	exitChan, attempts, reconnecting := c.exitChan, c.dialAttempts, c.reconnecting
+	c.lock.Unlock()
+
+	onConnStart := c.onConnStart
+	return func(conn giface.IConnection) {
+		if exitChan == nil {
+			conn.Stop()
+			return
+		}
+		select {
+		case <-exitChan:
+			conn.Stop()
+			return
+		default:
+		}
+
+		if onConnStart != nil {
+			onConnStart(conn)
+		}
+		if reconnecting && c.reconnect != nil && c.reconnect.OnReconnect != nil {
+			c.reconnect.OnReconnect(conn, attempts)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package gnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewReconnectConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *ReconnectConfig
+		want   ReconnectConfig
+	}{
+		{name: "nil", want: ReconnectConfig{MinDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second, Multiplier: 2, Jitter: 0.2}},
+		{name: "invalid", config: &ReconnectConfig{MinDelay: -1, MaxDelay: -1, Multiplier: 0.5, Jitter: 1.5},
+			want: ReconnectConfig{MinDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second, Multiplier: 2, Jitter: 0.2}},
+		{name: "min above default max", config: &ReconnectConfig{MinDelay: time.Minute},
+			want: ReconnectConfig{MinDelay: time.Minute, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.2}},
+		{name: "custom", config: &ReconnectConfig{MinDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 1.5, Jitter: 1, MaxAttempts: 3},
+			want: ReconnectConfig{MinDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 1.5, Jitter: 1, MaxAttempts: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newReconnectConfig(tt.config)
+			if got.MinDelay != tt.want.MinDelay || got.MaxDelay != tt.want.MaxDelay || got.Multiplier != tt.want.Multiplier ||
+				got.Jitter != tt.want.Jitter || got.MaxAttempts != tt.want.MaxAttempts {
+				t.Fatalf("newReconnectConfig() = %+v, want %+v", *got, tt.want)
+			}
+			if tt.config != nil && got == tt.config {
+				t.Fatal("newReconnectConfig() returns the config passed in instead of a copy")
+			}
+		})
+	}
+}
+
+func TestReconnectConfigDelay(t *testing.T) {
+	r := newReconnectConfig(&ReconnectConfig{MinDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.2})
+
+	tests := []struct {
+		failures int
+		base     time.Duration // the delay without jitter (未加抖动的延迟)
+	}{
+		{failures: 0, base: 100 * time.Millisecond},
+		{failures: 1, base: 200 * time.Millisecond},
+		{failures: 3, base: 800 * time.Millisecond},
+		{failures: 4, base: time.Second},
+		{failures: 100, base: time.Second},
+	}
+
+	for _, tt := range tests {
+		// The jitter takes up to 20% off the delay (抖动最多减少20%的延迟)
+		lo, hi := tt.base-tt.base/5, tt.base
+		for i := 0; i < 100; i++ {
+			if d := r.delay(tt.failures); d < lo || d > hi {
+				t.Fatalf("delay(%d) = %v, want in [%v, %v]", tt.failures, d, lo, hi)
+			}
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package gnet
 
 import (
+	"context"
+	"errors"
 	"math"
 	"sync"
+	"time"
 
-	"github.com/liyee/gray/gconf"
 	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/glog"
 	"github.com/liyee/gray/gpack"
 )
 
@@ -37,6 +40,10 @@ type Request struct {
 	handlers []giface.RouterHandler // router function slice(路由函数切片)
 	index    int8                   // router function slice index(路由函数切片索引)
 	keys     map[string]interface{} // keys 路由处理时可能会存取的上下文信息
+	ctx      context.Context        // the context of the request, created by Context on demand(请求的context，由Context按需创建)
+	cancel   context.CancelFunc     // cancels ctx once the handler returns(处理函数返回后取消ctx)
+	deadline time.Time              // the handler deadline, zero means none(处理函数的截止时间，零值表示不限制)
+	callID   uint32                 // the correlation ID if the request is a call, 0 otherwise(请求为调用时的关联ID，否则为0)
 }
 
 func (r *Request) GetResPonse() giface.IcResp {
@@ -60,7 +67,7 @@ func NewRequest(conn giface.IConnection, msg giface.IMessage) giface.IRequest {
 
 func GetRequest(conn giface.IConnection, msg giface.IMessage) giface.IRequest {
 	// 根据当前模式判断是否使用对象池
-	if gconf.GlobalObject.RequestPoolMode {
+	if configOf(conn).RequestPoolMode {
 		// 从对象池中取得一个 Request 对象,如果池子中没有可用的 Request 对象则会调用 allocateRequest 函数构造一个新的对象分配
 		r := RequestPool.Get().(*Request)
 		// 因为取出的 Request 对象可能是已存在也可能是新构造的,无论是哪种情况都应该初始化再返回使用
@@ -72,7 +79,7 @@ func GetRequest(conn giface.IConnection, msg giface.IMessage) giface.IRequest {
 
 func PutRequest(request giface.IRequest) {
 	// 判断是否开启了对象池模式
-	if gconf.GlobalObject.RequestPoolMode {
+	if configOf(request.GetConnection()).RequestPoolMode {
 		RequestPool.Put(request)
 	}
 }
@@ -92,6 +99,10 @@ func (r *Request) Reset(conn giface.IConnection, msg giface.IMessage) {
 	r.needNext = true
 	r.index = -1
 	r.keys = nil
+	r.ctx = nil
+	r.cancel = nil
+	r.deadline = time.Time{}
+	r.callID = 0
 }
 
 func (r *Request) Copy() giface.IRequest {
@@ -123,6 +134,10 @@ func (r *Request) Copy() giface.IRequest {
 	// 复制一份原本的 msg 信息
 	newRequest.msg = gpack.NewMessageByMsgID(r.msg.GetMsgID(), r.msg.GetDataLen(), r.msg.GetRawData())
 
+	// The copy outlives the handler, it keeps the values of the context but is neither cancelled nor has a deadline
+	// (复制的请求比处理函数存活更久，保留context中的值，但不会被取消也没有截止时间)
+	newRequest.ctx = context.WithoutCancel(r.Context())
+
 	return newRequest
 }
 
@@ -145,6 +160,85 @@ func (r *Request) Get(key string) (value interface{}, exists bool) {
 	return
 }
 
+// Context returns the context of the request. It is derived from the context of the connection, so it is
+// cancelled when the connection stops, it is also cancelled when the handler returns or the handler deadline
+// passes. It carries connID and msgID as glog fields, which the glog FX methods print.
+// (返回请求的context。它派生自连接的context，因此连接停止时被取消，处理函数返回或超过截止时间时也会被取消。
+// 它以glog字段的形式携带connID和msgID，glog的FX方法会输出这些字段)
+func (r *Request) Context() context.Context {
+	r.stepLock.Lock()
+	defer r.stepLock.Unlock()
+
+	if r.ctx == nil {
+		r.ctx, r.cancel = r.newContext()
+	}
+	return r.ctx
+}
+
+func (r *Request) newContext() (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	if r.conn != nil {
+		ctx = r.conn.Context()
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if r.conn != nil {
+		ctx = glog.WithField(ctx, "connID", r.conn.GetConnID())
+	}
+	if r.msg != nil {
+		ctx = glog.WithField(ctx, "msgID", r.msg.GetMsgID())
+	}
+
+	if !r.deadline.IsZero() {
+		return context.WithDeadline(ctx, r.deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// setDeadline sets the handler deadline before the handler runs. If an interceptor has already created the
+// context, it is wrapped so that the handler still sees the deadline.
+// (在处理函数执行前设置截止时间。如果拦截器已经创建了context，则对其包装，使处理函数仍能看到截止时间)
+func (r *Request) setDeadline(deadline time.Time) {
+	r.stepLock.Lock()
+	defer r.stepLock.Unlock()
+
+	r.deadline = deadline
+	if r.ctx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(r.ctx, deadline)
+	parentCancel := r.cancel
+	r.ctx = ctx
+	r.cancel = func() {
+		cancel()
+		parentCancel()
+	}
+}
+
+// done cancels the context once the handler returns (处理函数返回后取消context)
+func (r *Request) done() {
+	r.stepLock.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.stepLock.Unlock()
+}
+
+// Reply sends data back to the peer as the reply of the call, it fails if the request is not a call made with Call
+// (将data作为调用的应答发回对端，请求不是通过Call发起的调用时返回错误)
+func (r *Request) Reply(data []byte) error {
+	if r.callID == 0 {
+		return errors.New("the request is not a call")
+	}
+	if r.conn == nil {
+		return errors.New("the request has no connection to reply on")
+	}
+	return r.conn.SendMsg(r.msg.GetMsgID()|gpack.MsgIDReplyFlag, gpack.WithCorrelationID(r.callID, data))
+}
+
 func (r *Request) GetMessage() giface.IMessage {
 	return r.msg
 }
@@ -206,7 +300,7 @@ func (r *Request) Call() {
 }
 
 func (r *Request) Abort() {
-	if gconf.GlobalObject.RouterSlicesMode {
+	if configOf(r.conn).RouterSlicesMode {
 		r.index = int8(len(r.handlers))
 	} else {
 		r.stepLock.Lock()
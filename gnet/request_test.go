@@ -0,0 +1,81 @@
+package gnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/gpack"
+)
+
+func TestRequestContextDeadline(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name          string
+		contextBefore bool // an interceptor calls Context before the deadline is set (拦截器在设置截止时间前调用Context)
+		setDeadline   bool
+	}{
+		{name: "no deadline"},
+		{name: "deadline before context", setDeadline: true},
+		{name: "deadline after context", contextBefore: true, setDeadline: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRequest(nil, gpack.NewMsgPackage(1, nil)).(*Request)
+
+			var early context.Context
+			if tt.contextBefore {
+				early = r.Context()
+			}
+			if tt.setDeadline {
+				r.setDeadline(deadline)
+			}
+
+			ctx := r.Context()
+			got, ok := ctx.Deadline()
+			if ok != tt.setDeadline || (ok && !got.Equal(deadline)) {
+				t.Fatalf("Deadline() = %v, %v, want %v, %v", got, ok, deadline, tt.setDeadline)
+			}
+
+			r.done()
+			if ctx.Err() == nil {
+				t.Error("the context is not cancelled when the handler returns")
+			}
+			if early != nil && early.Err() == nil {
+				t.Error("the context created before the deadline is not cancelled when the handler returns")
+			}
+		})
+	}
+}
+
+func TestUnknownMsgIDFinishesRequest(t *testing.T) {
+	tests := []struct {
+		name             string
+		routerSlicesMode bool
+	}{
+		{name: "router"},
+		{name: "router slices", routerSlicesMode: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mh := newMsgHandler(&gconf.Config{RouterSlicesMode: tt.routerSlicesMode})
+			r := NewRequest(nil, gpack.NewMsgPackage(404, nil)).(*Request)
+			// An interceptor used the context before the request was dispatched (分发前拦截器使用了context)
+			ctx := r.Context()
+
+			if tt.routerSlicesMode {
+				mh.doMsgHandlerSlices(r, WorkerIDWithoutWorkerPool)
+			} else {
+				mh.doMsgHandler(r, WorkerIDWithoutWorkerPool)
+			}
+
+			if ctx.Err() == nil {
+				t.Fatal("the context of a request without router is not cancelled")
+			}
+		})
+	}
+}
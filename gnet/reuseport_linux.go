@@ -0,0 +1,27 @@
+//go:build linux
+
+package gnet
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported reports whether several sockets can be bound to the same port with SO_REUSEPORT
+// (是否支持通过SO_REUSEPORT将多个socket绑定到同一端口)
+const reusePortSupported = true
+
+// reusePortControl sets SO_REUSEPORT on the socket before it is bound, the kernel then balances the
+// incoming connections between all the sockets bound to the same address
+// (在socket绑定之前设置SO_REUSEPORT，内核会在绑定同一地址的所有socket之间均衡分配新连接)
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
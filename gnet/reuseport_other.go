@@ -0,0 +1,17 @@
+//go:build !linux
+
+package gnet
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortSupported reports whether several sockets can be bound to the same port with SO_REUSEPORT
+// (是否支持通过SO_REUSEPORT将多个socket绑定到同一端口)
+const reusePortSupported = false
+
+// reusePortControl is only implemented on Linux (仅在Linux下实现)
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT listeners are only supported on linux")
+}
@@ -1,14 +1,18 @@
 package gnet
 
 import (
-	"crypto/rand"
+	"context"
+	"crypto/sha1"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -22,6 +26,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/xtaci/kcp-go"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 type Server struct {
@@ -32,11 +37,20 @@ type Server struct {
 	WsPort    int
 	KcpPort   int
 
+	// The listeners started by the server, all of them share the same msgHandler and ConnMgr
+	// (服务器启动的监听列表，共用同一个msgHandler和ConnMgr)
+	Listeners []gconf.ListenerConfig
+
 	msgHandler giface.IMsgHandler
 
+	config *gconf.Config //该Server独立的配置，同一进程中的多个Server互不影响
+
 	RouterSlicesMode bool //路由模式
 	RequestPoolMode  bool //对象池模式
 	ConnMgr          giface.IConnManager
+	GroupMgr         giface.IGroupManager //连接分组(房间)管理
+
+	sessions *SessionManager //可恢复的会话，未开启时为nil
 
 	onConnStart func(conn giface.IConnection) //该Server的连接创建时Hook函数
 	onConnStop  func(conn giface.IConnection) //该Server的连接断开时的Hook函数
@@ -44,16 +58,37 @@ type Server struct {
 	packet giface.IDataPack //数据报文封包方式
 
 	exitChan chan struct{}            //异步捕获链接关闭状态
+	exitOnce sync.Once                //保证exitChan只关闭一次
+	kcpClose chan struct{}            //关闭KCP监听及其PacketConn，优雅关闭时在连接排空后才关闭
+	kcpOnce  sync.Once                //保证kcpClose只关闭一次
+	initOnce sync.Once                //保证解码器和worker工作池只初始化一次
 	decoder  giface.IDecoder          //断粘包解码器
 	hc       giface.IHeartbeatChecker //心跳检测器
 
 	upgrader *websocket.Upgrader //websocket
+	wsPath   string              //websocket升级路径
 
 	websocketAuth func(r *http.Request) error // websocket connection authentication
 
 	kcpConfig *KcpConfig
+	tcpConfig *TcpConfig //tcp和websocket连接的socket选项
+
+	ipLimiter *IPLimiter //单IP并发链接数及建链速率限制
+
+	metrics *serverMetrics //连接、消息和处理耗时的统计，未开启时为nil
 
-	cID uint64 // connection id
+	proxyTimeout time.Duration //读取PROXY protocol头部的超时时间
+
+	addrLock       sync.RWMutex          //保护addrs和handoffSockets
+	addrs          map[string][]net.Addr //各传输协议监听实际绑定的地址
+	handoffSockets []handoffSocket       //平滑重启时交给子进程的监听socket
+
+	certOnce     sync.Once     //证书源只创建一次
+	certReloader *CertReloader //TCP和websocket TLS监听共用的可热加载证书源
+	certErr      error         //创建证书源的错误
+
+	cID       uint64                  // connection id
+	connIDGen giface.IConnIDGenerator //连接ID生成器，为nil时使用自增ID
 }
 
 type KcpConfig struct {
@@ -87,6 +122,84 @@ type KcpConfig struct {
 	// FEC parity shards, default 0.
 	// (FEC校验分片,用于前向纠错比例配制) 默认是0
 	KcpFecParityShards int
+	// The block cipher of KCP packets, see the gconf.KcpCrypt* constants, default "none".
+	// (KCP报文加密算法，见gconf.KcpCrypt*常量) 默认是"none"不加密
+	KcpCrypt string
+	// The shared secret from which the cipher key is derived, both peers must use the same one.
+	// (派生加密密钥的共享密钥，两端必须一致)
+	KcpKey string
+	// The salt of the key derivation, a built-in salt is used if it is empty.
+	// (密钥派生的盐，为空时使用内置值)
+	KcpSalt string
+}
+
+// The default salt of the KCP key derivation (KCP密钥派生的默认盐)
+const defaultKcpSalt = "gray-kcp"
+
+// The iterations of the PBKDF2 key derivation (PBKDF2密钥派生的迭代次数)
+const kcpKeyIterations = 4096
+
+// newKcpConfig creates the KCP config from the KCP fields of the config
+// (根据配置中的KCP字段创建KCP配置)
+func newKcpConfig(config *gconf.Config) *KcpConfig {
+	return &KcpConfig{
+		KcpACKNoDelay:      config.KcpACKNoDelay,
+		KcpStreamMode:      config.KcpStreamMode,
+		KcpNoDelay:         config.KcpNoDelay,
+		KcpInterval:        config.KcpInterval,
+		KcpResend:          config.KcpResend,
+		KcpNc:              config.KcpNc,
+		KcpSendWindow:      config.KcpSendWindow,
+		KcpRecvWindow:      config.KcpRecvWindow,
+		KcpFecDataShards:   config.KcpFecDataShards,
+		KcpFecParityShards: config.KcpFecParityShards,
+		KcpCrypt:           config.KcpCrypt,
+		KcpKey:             config.KcpKey,
+		KcpSalt:            config.KcpSalt,
+	}
+}
+
+// apply sets the KCP options of a session
+// (为KCP会话设置KCP参数)
+func (k *KcpConfig) apply(sess *kcp.UDPSession) {
+	sess.SetACKNoDelay(k.KcpACKNoDelay)
+	sess.SetStreamMode(k.KcpStreamMode)
+	sess.SetNoDelay(k.KcpNoDelay, k.KcpInterval, k.KcpResend, k.KcpNc)
+	sess.SetWindowSize(k.KcpSendWindow, k.KcpRecvWindow)
+}
+
+// newBlockCrypt creates the block cipher of KCP packets, it returns nil if encryption is disabled.
+// The cipher key is derived from KcpKey and KcpSalt with PBKDF2.
+// (创建KCP报文的加密算法，未启用加密时返回nil。加密密钥由KcpKey和KcpSalt通过PBKDF2派生)
+func (k *KcpConfig) newBlockCrypt() (kcp.BlockCrypt, error) {
+	if k.KcpCrypt == "" || k.KcpCrypt == gconf.KcpCryptNone {
+		return nil, nil
+	}
+
+	if k.KcpKey == "" {
+		return nil, fmt.Errorf("kcp crypt %s requires a non-empty KcpKey", k.KcpCrypt)
+	}
+
+	salt := k.KcpSalt
+	if salt == "" {
+		salt = defaultKcpSalt
+	}
+	pass := pbkdf2.Key([]byte(k.KcpKey), []byte(salt), kcpKeyIterations, 32, sha1.New)
+
+	switch k.KcpCrypt {
+	case gconf.KcpCryptAES:
+		return kcp.NewAESBlockCrypt(pass)
+	case gconf.KcpCryptAES128:
+		return kcp.NewAESBlockCrypt(pass[:16])
+	case gconf.KcpCryptAES192:
+		return kcp.NewAESBlockCrypt(pass[:24])
+	case gconf.KcpCryptSalsa20:
+		return kcp.NewSalsa20BlockCrypt(pass)
+	case gconf.KcpCryptSM4:
+		return kcp.NewSM4BlockCrypt(pass[:16])
+	default:
+		return nil, fmt.Errorf("unsupported kcp crypt: %s", k.KcpCrypt)
+	}
 }
 
 func newServerWithConfig(config *gconf.Config, ipVersion string, opts ...Option) giface.IServer {
@@ -99,33 +212,51 @@ func newServerWithConfig(config *gconf.Config, ipVersion string, opts ...Option)
 		Port:             config.TcpPort,
 		WsPort:           config.WsPort,
 		KcpPort:          config.KcpPort,
-		msgHandler:       newMsgHandler(),
+		Listeners:        config.GetListeners(),
+		msgHandler:       newMsgHandler(config),
+		config:           config,
 		RouterSlicesMode: config.RouterSlicesMode,
 		RequestPoolMode:  config.RequestPoolMode,
-		ConnMgr:          newConnManager(),
-		exitChan:         nil,
+		ConnMgr:          newConnManager(config.ConnIndexPolicy),
+		GroupMgr:         newGroupManager(),
+		ipLimiter:        NewIPLimiter(config.MaxConnPerIP, config.AcceptRatePerIP, config.AcceptBurstPerIP),
+		proxyTimeout:     config.ProxyHeaderTimeoutDuration(),
+		exitChan:         make(chan struct{}),
+		kcpClose:         make(chan struct{}),
 		// Default to using Zinx's TLV data pack format
 		// (默认使用zinx的TLV封包方式)
-		packet:  gpack.Factory().NewPack(giface.GrayDataPack),
+		packet:  gpack.Factory().NewPackWithConfig(giface.GrayDataPack, config),
 		decoder: gdecoder.NewTLVDecoder(), // Default to using TLV decode (默认使用TLV的解码方式)
+		wsPath:  config.WsPath,
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize: int(config.IOReadBuffSize),
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		},
-		kcpConfig: &KcpConfig{
-			KcpACKNoDelay:      config.KcpACKNoDelay,
-			KcpStreamMode:      config.KcpStreamMode,
-			KcpNoDelay:         config.KcpNoDelay,
-			KcpInterval:        config.KcpInterval,
-			KcpResend:          config.KcpResend,
-			KcpNc:              config.KcpNc,
-			KcpSendWindow:      config.KcpSendWindow,
-			KcpRecvWindow:      config.KcpRecvWindow,
-			KcpFecDataShards:   config.KcpFecDataShards,
-			KcpFecParityShards: config.KcpFecParityShards,
-		},
+		kcpConfig: newKcpConfig(config),
+		tcpConfig: newTcpConfig(config),
+	}
+
+	// Sessions survive the connections for the grace period, the server handles the resume requests of clients
+	// (会话在宽限期内比连接存活更久，服务器处理客户端的恢复请求)
+	if config.SessionGracePeriod > 0 {
+		s.sessions = newSessionManager(config)
+		resumeRouter := &sessionResumeRouter{sessions: s.sessions}
+		if s.RouterSlicesMode {
+			s.AddRouterSlices(giface.SessionResumeMsgID, resumeRouter.Handle)
+		} else {
+			s.AddRouter(giface.SessionResumeMsgID, resumeRouter)
+		}
+	}
+
+	// The msg handler counts the messages and handler latencies of the server
+	// (消息处理模块统计服务器的消息和处理耗时)
+	if config.Metrics || config.MetricsAddr != "" {
+		s.metrics = newServerMetrics(config.CallMode)
+		if mh, ok := s.msgHandler.(*MsgHandler); ok {
+			mh.metrics = s.metrics
+		}
 	}
 
 	for _, opt := range opts {
@@ -137,26 +268,25 @@ func newServerWithConfig(config *gconf.Config, ipVersion string, opts ...Option)
 	return s
 }
 
-// (创建一个服务器句柄)
+// (创建一个服务器句柄，使用全局配置的副本)
 func NewServer(opts ...Option) giface.IServer {
-	return newServerWithConfig(gconf.GlobalObject, "tcp", opts...)
+	return newServerWithConfig(gconf.GlobalObject.Clone(), "tcp", opts...)
 }
 
 // (创建一个服务器句柄)
 func NewUserConfServer(config *gconf.Config, opts ...Option) giface.IServer {
 
-	// Refresh user configuration to global configuration variable
-	// (刷新用户配置到全局配置变量)
-	gconf.UserConfToGlobal(config)
-
-	s := newServerWithConfig(gconf.GlobalObject, "tcp4", opts...)
+	// Merge the user configuration into a copy of the global configuration, the global one is left untouched
+	// (将用户配置合并到全局配置的副本中，不修改全局配置)
+	s := newServerWithConfig(gconf.NewUserConf(config), "tcp4", opts...)
 	return s
 }
 
 // (创建一个默认自带一个Recover处理器的服务器句柄)
 func NewDefaultRouterSlicesServer(opts ...Option) giface.IServer {
-	gconf.GlobalObject.RouterSlicesMode = true
-	s := newServerWithConfig(gconf.GlobalObject, "tcp", opts...)
+	config := gconf.GlobalObject.Clone()
+	config.RouterSlicesMode = true
+	s := newServerWithConfig(config, "tcp", opts...)
 	s.Use(RouterRecovery)
 	return s
 }
@@ -168,10 +298,8 @@ func NewUserConfDefaultRouterSlicesServer(config *gconf.Config, opts ...Option)
 		panic("RouterSlicesMode is false")
 	}
 
-	// Refresh user configuration to global configuration variable (刷新用户配置到全局配置变量)
-	gconf.UserConfToGlobal(config)
-
-	s := newServerWithConfig(gconf.GlobalObject, "tcp4", opts...)
+	// Merge the user configuration into a copy of the global configuration (将用户配置合并到全局配置的副本中)
+	s := newServerWithConfig(gconf.NewUserConf(config), "tcp4", opts...)
 	s.Use(RouterRecovery)
 	return s
 }
@@ -190,50 +318,143 @@ func (s *Server) StartConn(conn giface.IConnection) {
 	conn.Start()
 }
 
+// startLimitedConn starts a connection admitted by the IPLimiter and gives back its slot once it is closed
+// (启动经IPLimiter接纳的连接，并在连接关闭后归还名额)
+func (s *Server) startLimitedConn(conn giface.IConnection, ip string) {
+	defer s.ipLimiter.Release(ip)
+	transport := transportOf(conn)
+	s.metrics.connAccepted(transport)
+	defer s.metrics.connClosed(transport)
+	s.StartConn(conn)
+}
+
+// IPLimiter returns the per-IP admission control of the server
+// (返回服务器的单IP准入控制)
+func (s *Server) IPLimiter() *IPLimiter {
+	return s.ipLimiter
+}
+
 func (s *Server) ListenTcpConn() {
-	// 1. Get a TCP address
-	addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
-	if err != nil {
-		glog.Ins().ErrorF("[START] resolve tcp addr err: %v\n", err)
-		return
+	s.listenTcpConn(s.IP, s.Port, false, 0)
+}
+
+// listenTcpConn listens to ip:port and serves it, with reusePort > 1 it opens that many SO_REUSEPORT
+// sockets on Linux, each one served by its own accept loop
+// (监听ip:port并提供服务，reusePort > 1时在Linux下打开相应数量的SO_REUSEPORT socket，每个socket由独立的Accept循环提供服务)
+func (s *Server) listenTcpConn(ip string, port int, proxyProtocol bool, reusePort int) {
+	glog.Ins().InfoF("[START] TCP Server name: %s,listener at IP: %s, Port %d is starting", s.Name, ip, port)
+
+	if reusePort > 1 && !reusePortSupported {
+		glog.Ins().ErrorF("[START] SO_REUSEPORT is not supported on this platform, listening with a single socket")
+		reusePort = 1
+	}
+	if reusePort < 1 {
+		reusePort = 1
 	}
 
-	// 2. Listen to the server address
-	var listener net.Listener
-	if gconf.GlobalObject.CertFile != "" && gconf.GlobalObject.PrivateKeyFile != "" {
-		// Read certificate and private key
-		crt, err := tls.LoadX509KeyPair(gconf.GlobalObject.CertFile, gconf.GlobalObject.PrivateKeyFile)
-		if err != nil {
-			panic(err)
+	// 1. Take over the listeners passed by systemd or the parent process, otherwise listen to the server address
+	// (接管systemd或父进程传入的监听，否则监听服务器地址)
+	listeners := make([]net.Listener, 0, reusePort)
+	for len(listeners) < reusePort {
+		listener := takeInheritedListener("tcp", ip, port)
+		if listener == nil {
+			var err error
+			if listener, err = s.newTcpListener(ip, port, reusePort > 1); err != nil {
+				for _, l := range listeners {
+					_ = l.Close()
+				}
+				panic(err)
+			}
 		}
 
-		// TLS connection
-		tlsConfig := &tls.Config{}
-		tlsConfig.Certificates = []tls.Certificate{crt}
-		tlsConfig.Time = time.Now
-		tlsConfig.Rand = rand.Reader
-		listener, err = tls.Listen(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port), tlsConfig)
-		if err != nil {
-			panic(err)
+		// With port 0 the other sockets are bound to the port chosen by the kernel for the first one
+		// (端口为0时，其余socket绑定到内核为第一个socket选择的端口)
+		if port == 0 {
+			port = listener.Addr().(*net.TCPAddr).Port
 		}
-	} else {
-		listener, err = net.ListenTCP(s.IPVersion, addr)
+
+		// The PROXY protocol header is sent in plain text before the TLS handshake
+		// (PROXY protocol头部在TLS握手之前以明文发送)
+		if proxyProtocol {
+			listener = newProxyProtoListener(listener, s.proxyTimeout)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// 2. Start server network connection business, one accept loop per socket
+	// (启动服务器网络连接业务，每个socket一个Accept循环)
+	for _, listener := range listeners[1:] {
+		go func(listener net.Listener) {
+			if err := s.ServeListener(listener); err != nil {
+				glog.Ins().ErrorF("[START] serve tcp listener %s err: %v", listener.Addr(), err)
+			}
+		}(listener)
+	}
+	if err := s.ServeListener(listeners[0]); err != nil {
+		panic(err)
+	}
+}
+
+// newTcpListener listens to ip:port, with SO_REUSEPORT set if reusePort is true
+// (监听ip:port，reusePort为true时设置SO_REUSEPORT)
+func (s *Server) newTcpListener(ip string, port int, reusePort bool) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", ip, port))
+	if err != nil {
+		return nil, err
+	}
+	if !reusePort {
+		return net.ListenTCP(s.IPVersion, addr)
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), s.IPVersion, addr.String())
+}
+
+// ServeListener serves the connections accepted by a listener bound by the caller, e.g. on port 0 or
+// inherited from the parent process, with TLS if configured. Unix listeners are served without TLS.
+// It blocks until the server stops and then closes the listener. Start does not need to be called first.
+// (使用调用方绑定的监听(如0端口或从父进程继承的监听)提供服务，配置了证书时使用TLS，unix监听不使用TLS。
+// 阻塞直到服务停止，然后关闭该监听，无需先调用Start)
+func (s *Server) ServeListener(listener net.Listener) error {
+	s.init()
+
+	mode := gconf.ServerModeTcp
+	if listener.Addr().Network() == "unix" {
+		mode = gconf.ServerModeUnix
+	}
+	s.addAddr(mode, listener.Addr())
+	s.addHandoffSocket(mode, listener)
+
+	if mode == gconf.ServerModeTcp && s.useTLS() {
+		// TLS connection
+		tlsConfig, err := s.newTLSConfig()
 		if err != nil {
-			panic(err)
+			_ = listener.Close()
+			return err
 		}
+		listener = tls.NewListener(listener, tlsConfig)
 	}
 
-	// 3. Start server network connection business
+	glog.Ins().InfoF("[START] %s server name: %s, listening at %s", strings.ToUpper(mode), s.Name, listener.Addr())
+	s.serveConnListener(listener)
+	return nil
+}
+
+// serveConnListener runs the accept loop of a stream listener until the server stops
+// (运行流式监听的Accept循环，直到服务停止)
+func (s *Server) serveConnListener(listener net.Listener) {
+	// Every accept loop backs off on its own (每个Accept循环独立退避)
+	delay := &acceptDelay{}
 	go func() {
 		for {
-			// 3.1 Set the maximum connection control for the server. If it exceeds the maximum connection, wait.
+			// 1. Set the maximum connection control for the server. If it exceeds the maximum connection, wait.
 			// (设置服务器最大连接控制,如果超过最大连接，则等待)
-			if s.ConnMgr.Len() >= gconf.GlobalObject.MaxConn {
-				glog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", gconf.GlobalObject.MaxConn, AcceptDelay.duration)
-				AcceptDelay.Delay()
+			if s.ConnMgr.Len() >= s.config.MaxConn {
+				glog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", s.config.MaxConn, delay.duration)
+				delay.Delay()
 				continue
 			}
-			// 3.2 Block and wait for a client to establish a connection request.
+			// 2. Block and wait for a client to establish a connection request.
 			// (阻塞等待客户端建立连接请求)
 			conn, err := listener.Accept()
 			if err != nil {
@@ -243,19 +464,28 @@ func (s *Server) ListenTcpConn() {
 					return
 				}
 				glog.Ins().ErrorF("Accept err: %v", err)
-				AcceptDelay.Delay()
+				delay.Delay()
 				continue
 			}
 
-			AcceptDelay.Reset()
-
-			// 3.4 Handle the business method for this new connection request. At this time, the handler and conn should be bound.
-			// (处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的)
-			newCid := atomic.AddUint64(&s.cID, 1)
-			dealConn := newServerConn(s, conn, newCid)
-
-			go s.StartConn(dealConn)
+			delay.Reset()
+
+			// 3. Read the PROXY protocol header in its own goroutine, so a slow peer can not block the accept loop
+			// (在独立协程中读取PROXY protocol头部，避免慢速对端阻塞Accept循环)
+			if pc, ok := asProxyProtoConn(conn); ok {
+				go func(conn net.Conn) {
+					if err := pc.readHeader(); err != nil {
+						glog.Ins().ErrorF("read proxy protocol header from %s err: %v", pc.Conn.RemoteAddr(), err)
+						s.metrics.connRejected(gconf.ServerModeTcp, rejectProxyHeader)
+						_ = conn.Close()
+						return
+					}
+					s.acceptConn(conn)
+				}(conn)
+				continue
+			}
 
+			s.acceptConn(conn)
 		}
 	}()
 	select {
@@ -266,104 +496,355 @@ func (s *Server) ListenTcpConn() {
 		}
 	}
 }
-func (s *Server) ListenWebsocketConn() {
-	glog.Ins().InfoF("[START] WEBSOCKET Server name: %s,listener at IP: %s, Port %d is starting", s.Name, s.IP, s.WsPort)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// 1. Check if the server has reached the maximum allowed number of connections
-		// (设置服务器最大连接控制,如果超过最大连接，则等待)
-		if s.ConnMgr.Len() >= gconf.GlobalObject.MaxConn {
-			glog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", gconf.GlobalObject.MaxConn, AcceptDelay.duration)
-			AcceptDelay.Delay()
+
+// acceptConn applies the per-IP admission control to an accepted stream connection and starts it
+// (对已Accept的流式连接进行单IP准入控制并启动该连接)
+func (s *Server) acceptConn(conn net.Conn) {
+	// 1. Per-IP admission control, the rejected connection is closed at once
+	// (单IP准入控制，被拒绝的连接立即关闭)
+	ip := remoteIP(conn.RemoteAddr().String())
+	if !s.ipLimiter.Acquire(ip) {
+		// The network of the local address is the transport, "tcp" or "unix" (本地地址的网络类型即传输协议，"tcp"或"unix")
+		s.metrics.connRejected(conn.LocalAddr().Network(), rejectIPLimit)
+		_ = conn.Close()
+		return
+	}
+
+	// 2. Set the tcp socket options (设置tcp socket选项)
+	s.applyTcpConfig(conn)
+
+	// 3. Handle the business method for this new connection request. At this time, the handler and conn should be bound.
+	// (处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的)
+	newCid := s.nextConnID()
+	dealConn := newServerConn(s, conn, newCid)
+
+	go s.startLimitedConn(dealConn, ip)
+}
+
+// applyTcpConfig sets the tcp socket options of an accepted connection, a failure is logged and the connection kept
+// (为已Accept的连接设置tcp socket选项，失败时记录日志并保留该连接)
+func (s *Server) applyTcpConfig(conn net.Conn) {
+	if err := s.tcpConfig.apply(conn); err != nil {
+		glog.Ins().ErrorF("set tcp socket options of %s err: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (s *Server) listenUnixConn(path string) {
+	glog.Ins().InfoF("[START] UNIX Server name: %s,listener at Path: %s is starting", s.Name, path)
+
+	// 1. Take over the listener passed by systemd or the parent process (接管systemd或父进程传入的监听)
+	listener := takeInheritedListener("unix", path, 0)
+	if listener == nil {
+		// 2. Remove the socket file left by the previous process, abstract sockets ("@" prefix) have no file
+		// (删除上一个进程遗留的socket文件，抽象命名空间的socket("@"开头)没有文件)
+		if err := removeStaleUnixSocket(path); err != nil {
+			glog.Ins().ErrorF("[START] remove stale unix socket err: %v", err)
 			return
 		}
-		// 2. If websocket authentication is required, set the authentication information
-		// (如果需要 websocket 认证请设置认证信息)
-		if s.websocketAuth != nil {
-			err := s.websocketAuth(r)
-			if err != nil {
-				glog.Ins().ErrorF(" websocket auth err:%v", err)
-				w.WriteHeader(401)
-				AcceptDelay.Delay()
-				return
-			}
+
+		// 3. Listen to the socket path
+		var err error
+		listener, err = net.Listen("unix", path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// 4. Start server network connection business
+	if err := s.ServeListener(listener); err != nil {
+		panic(err)
+	}
+}
+
+// removeStaleUnixSocket removes the socket file at path if it exists
+// (如果path处存在socket文件则删除)
+func removeStaleUnixSocket(path string) error {
+	if path == "" || strings.HasPrefix(path, "@") {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
 		}
-		// 3. Check if there is a subprotocol specified in the header
-		// (判断 header 里面是有子协议)
-		if len(r.Header.Get("Sec-Websocket-Protocol")) > 0 {
-			s.upgrader.Subprotocols = websocket.Subprotocols(r)
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	return os.Remove(path)
+}
+func (s *Server) ListenWebsocketConn() {
+	s.listenWebsocketConn(s.IP, s.WsPort, s.wsPath, false)
+}
+
+// serveWebsocket upgrades an HTTP request to a websocket connection
+// (将HTTP请求升级为websocket连接)
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	// 0. Reject new connections once the server is stopping
+	// (服务停止后拒绝新的连接)
+	if s.isStopping() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	// 1. Check if the server has reached the maximum allowed number of connections
+	// (设置服务器最大连接控制,如果超过最大连接，则等待)
+	if s.ConnMgr.Len() >= s.config.MaxConn {
+		glog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", s.config.MaxConn, AcceptDelay.current())
+		s.metrics.connRejected(gconf.ServerModeWebSocket, rejectMaxConn)
+		AcceptDelay.Delay()
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	// 2. Per-IP admission control (单IP准入控制)
+	ip := remoteIP(r.RemoteAddr)
+	if !s.ipLimiter.Acquire(ip) {
+		s.metrics.connRejected(gconf.ServerModeWebSocket, rejectIPLimit)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	admitted := false
+	defer func() {
+		if !admitted {
+			s.ipLimiter.Release(ip)
 		}
-		// 4. Upgrade the connection to a websocket connection
-		// (升级成 websocket 连接)
-		conn, err := s.upgrader.Upgrade(w, r, nil)
+	}()
+	// 3. If websocket authentication is required, set the authentication information
+	// (如果需要 websocket 认证请设置认证信息)
+	if s.websocketAuth != nil {
+		err := s.websocketAuth(r)
 		if err != nil {
-			glog.Ins().ErrorF("new websocket err:%v", err)
-			w.WriteHeader(500)
+			glog.Ins().ErrorF(" websocket auth err:%v", err)
+			s.metrics.connRejected(gconf.ServerModeWebSocket, rejectAuth)
+			w.WriteHeader(401)
 			AcceptDelay.Delay()
 			return
 		}
-		AcceptDelay.Reset()
-		// 5. Handle the business logic of the new connection, which should already be bound to a handler and conn
-		// 5. 处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
-		newCid := atomic.AddUint64(&s.cID, 1)
-		wsConn := newWebsocketConn(s, conn, newCid)
-		go s.StartConn(wsConn)
+	}
+	// 4. Check if there is a subprotocol specified in the header, the upgrader is copied since requests are
+	// upgraded concurrently
+	// (判断 header 里面是有子协议，请求会被并发升级，因此复制一份upgrader)
+	upgrader := s.upgrader
+	if len(r.Header.Get("Sec-Websocket-Protocol")) > 0 {
+		upgrader = new(websocket.Upgrader)
+		*upgrader = *s.upgrader
+		upgrader.Subprotocols = websocket.Subprotocols(r)
+	}
+	// 5. Upgrade the connection to a websocket connection
+	// (升级成 websocket 连接)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		glog.Ins().ErrorF("new websocket err:%v", err)
+		s.metrics.connRejected(gconf.ServerModeWebSocket, rejectUpgrade)
+		w.WriteHeader(500)
+		AcceptDelay.Delay()
+		return
+	}
+	AcceptDelay.Reset()
+	s.applyTcpConfig(conn.NetConn())
+	// 6. Handle the business logic of the new connection, which should already be bound to a handler and conn
+	// 6. 处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
+	newCid := s.nextConnID()
+	wsConn := newWebsocketConn(s, conn, newCid)
+	admitted = true
+	go s.startLimitedConn(wsConn, ip)
+}
 
-	})
+func (s *Server) listenWebsocketConn(ip string, port int, path string, proxyProtocol bool) {
+	if path == "" {
+		path = s.wsPath
+	}
+	if path == "" {
+		path = "/"
+	}
+	glog.Ins().InfoF("[START] WEBSOCKET Server name: %s,listener at IP: %s, Port %d, Path %s is starting", s.Name, ip, port, path)
 
-	err := http.ListenAndServe(fmt.Sprintf("%s:%d", s.IP, s.WsPort), nil)
-	if err != nil {
+	// Take over the listener passed by systemd or the parent process (接管systemd或父进程传入的监听)
+	listener := takeInheritedListener("tcp", ip, port)
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", ip, port))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// The http.Server reads the PROXY protocol header in the goroutine of each connection
+	// (http.Server在每个连接自己的协程中读取PROXY protocol头部)
+	if proxyProtocol {
+		listener = newProxyProtoListener(listener, s.proxyTimeout)
+	}
+
+	if err := s.ServeWebsocketListener(listener, path); err != nil {
 		panic(err)
 	}
 }
 
+// ServeWebsocketListener serves websocket upgrades on path ("/" if empty) over a listener bound by the caller,
+// with wss if WsTLS is set. It blocks until the server stops. Start does not need to be called first.
+// (在调用方绑定的监听上提供websocket服务，path为空时为"/"，设置WsTLS时使用wss。阻塞直到服务停止，无需先调用Start)
+func (s *Server) ServeWebsocketListener(listener net.Listener, path string) error {
+	s.init()
+
+	if path == "" {
+		path = "/"
+	}
+	s.addAddr(gconf.ServerModeWebSocket, listener.Addr())
+	s.addHandoffSocket(gconf.ServerModeWebSocket, listener)
+
+	// Serve wss with the same TLS config as the TCP listener
+	// (使用与TCP监听相同的TLS配置提供wss服务)
+	if s.config.WsTLS && s.useTLS() {
+		tlsConfig, err := s.newTLSConfig()
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	// Every websocket listener owns its http.Server, so several servers can live in one process
+	// (每个websocket监听拥有独立的http.Server，因此同一进程中可以运行多个服务)
+	mux := http.NewServeMux()
+	mux.Handle(path, s.WebsocketHandler())
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-s.exitChan
+		err := httpServer.Shutdown(context.Background())
+		if err != nil {
+			glog.Ins().ErrorF("websocket http server shutdown err: %v", err)
+		}
+	}()
+
+	glog.Ins().InfoF("[START] WEBSOCKET server name: %s, listening at %s, Path %s", s.Name, listener.Addr(), path)
+	err := httpServer.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
 func (s *Server) ListenKcpConn() {
+	s.listenKcpConn(s.IP, s.KcpPort)
+}
 
-	// 1. Listen to the server address
-	listener, err := kcp.ListenWithOptions(fmt.Sprintf("%s:%d", s.IP, s.KcpPort), nil, s.kcpConfig.KcpFecDataShards, s.kcpConfig.KcpFecParityShards)
-	if err != nil {
-		glog.Ins().ErrorF("[START] resolve KCP addr err: %v\n", err)
-		return
+func (s *Server) listenKcpConn(ip string, port int) {
+	// 1. Take over the packet conn passed by systemd or the parent process, otherwise listen to the server address
+	// (接管systemd或父进程传入的PacketConn，否则监听服务器地址)
+	conn := takeInheritedPacketConn(ip, port)
+	if conn == nil {
+		addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", ip, port))
+		if err != nil {
+			glog.Ins().ErrorF("[START] resolve KCP addr err: %v\n", err)
+			return
+		}
+
+		conn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			glog.Ins().ErrorF("[START] listen KCP addr err: %v\n", err)
+			return
+		}
 	}
 
-	glog.Ins().InfoF("[START] KCP server listening at IP: %s, Port %d, Addr %s", s.IP, s.KcpPort, listener.Addr().String())
 	// 2. Start server network connection business
+	if err := s.ServeKcpPacketConn(conn); err != nil {
+		glog.Ins().ErrorF("[START] serve KCP err: %v", err)
+	}
+}
+
+// ServeKcpPacketConn serves KCP sessions over a packet conn bound by the caller, e.g. on port 0 or
+// inherited from the parent process. It blocks until the server stops and then closes conn.
+// Start does not need to be called first.
+// (在调用方绑定的PacketConn(如0端口或从父进程继承)上提供KCP服务，阻塞直到服务停止后关闭conn，无需先调用Start)
+func (s *Server) ServeKcpPacketConn(conn net.PacketConn) error {
+	s.init()
+
+	block, err := s.kcpConfig.newBlockCrypt()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	listener, err := kcp.ServeConn(block, s.kcpConfig.KcpFecDataShards, s.kcpConfig.KcpFecParityShards, conn)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	s.addHandoffSocket(gconf.ServerModeKcp, conn)
+
+	s.serveKcpListener(listener)
+	return nil
+}
+
+// serveKcpListener runs the accept loop of a KCP listener until the server stops
+// (运行KCP监听的Accept循环，直到服务停止)
+func (s *Server) serveKcpListener(listener *kcp.Listener) {
+	s.addAddr(gconf.ServerModeKcp, listener.Addr())
+
+	// Every accept loop backs off on its own (每个Accept循环独立退避)
+	delay := &acceptDelay{}
+
+	glog.Ins().InfoF("[START] KCP server name: %s, listening at %s", s.Name, listener.Addr())
 	go func() {
 		for {
 			// 2.1 Set the maximum connection control for the server. If it exceeds the maximum connection, wait.
 			// (设置服务器最大连接控制,如果超过最大连接，则等待)
-			if s.ConnMgr.Len() >= gconf.GlobalObject.MaxConn {
-				glog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", gconf.GlobalObject.MaxConn, AcceptDelay.duration)
-				AcceptDelay.Delay()
+			if s.ConnMgr.Len() >= s.config.MaxConn {
+				glog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", s.config.MaxConn, delay.duration)
+				delay.Delay()
 				continue
 			}
 			// 2.2 Block and wait for a client to establish a connection request.
 			// (阻塞等待客户端建立连接请求)
 			conn, err := listener.Accept()
 			if err != nil {
+				if errors.Is(err, io.ErrClosedPipe) {
+					glog.Ins().ErrorF("KCP listener closed")
+					return
+				}
 				glog.Ins().ErrorF("Accept KCP err: %v", err)
-				AcceptDelay.Delay()
+				delay.Delay()
+				continue
+			}
+
+			delay.Reset()
+
+			// The listener stays open while the server drains, the sessions accepted meanwhile are refused
+			// (服务排空期间监听保持打开，期间Accept到的会话直接拒绝)
+			if s.isStopping() {
+				_ = conn.Close()
 				continue
 			}
 
-			AcceptDelay.Reset()
+			// 2.3 Per-IP admission control, the rejected session is closed at once
+			// (单IP准入控制，被拒绝的会话立即关闭)
+			ip := remoteIP(conn.RemoteAddr().String())
+			if !s.ipLimiter.Acquire(ip) {
+				s.metrics.connRejected(gconf.ServerModeKcp, rejectIPLimit)
+				_ = conn.Close()
+				continue
+			}
 
-			// 3.4 Handle the business method for this new connection request. At this time, the handler and conn should be bound.
+			// 2.4 Handle the business method for this new connection request. At this time, the handler and conn should be bound.
 			// (处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn 是绑定的)
-			newCid := atomic.AddUint64(&s.cID, 1)
+			newCid := s.nextConnID()
 
 			kcpConn := conn.(*kcp.UDPSession)
-			kcpConn.SetACKNoDelay(s.kcpConfig.KcpACKNoDelay)
-			kcpConn.SetStreamMode(s.kcpConfig.KcpStreamMode)
-			kcpConn.SetNoDelay(s.kcpConfig.KcpNoDelay, s.kcpConfig.KcpInterval, s.kcpConfig.KcpResend, s.kcpConfig.KcpNc)
-			kcpConn.SetWindowSize(s.kcpConfig.KcpSendWindow, s.kcpConfig.KcpRecvWindow)
+			s.kcpConfig.apply(kcpConn)
 
 			dealConn := newKcpServerConn(s, kcpConn, newCid)
 
-			go s.StartConn(dealConn)
+			go s.startLimitedConn(dealConn, ip)
 		}
 	}()
+	// Closing a KCP listener also closes its packet conn and thereby every session on it, so unlike the stream
+	// listeners it is kept open until the connections are drained
+	// (关闭KCP监听会同时关闭其PacketConn及其上的所有会话，因此与流式监听不同，它在连接排空后才关闭)
 	select {
-	case <-s.exitChan:
+	case <-s.kcpClose:
 		err := listener.Close()
 		if err != nil {
 			glog.Ins().ErrorF("KCP listener close err: %v", err)
@@ -374,32 +855,123 @@ func (s *Server) ListenKcpConn() {
 // Start the network service
 // (开启网络服务)
 func (s *Server) Start() {
-	glog.Ins().InfoF("[START] Server name: %s,listener at IP: %s, Port %d is starting", s.Name, s.IP, s.Port)
-	s.exitChan = make(chan struct{})
+	glog.Ins().InfoF("[START] Server name: %s, listeners: %+v is starting", s.Name, s.Listeners)
+	s.init()
 
-	// Add decoder to interceptors head
-	// (将解码器添加到拦截器最前面)
-	if s.decoder != nil {
-		s.msgHandler.SetHeadInterceptor(s.decoder)
+	// Start a goroutine for every listener to handle server listener business
+	// (为每个监听开启一个go去做服务端Listener业务)
+	for _, l := range s.Listeners {
+		s.startListener(l)
 	}
-	// Start worker pool mechanism
-	// (启动worker工作池机制)
-	s.msgHandler.StartWorkerPool()
+}
+
+// init prepares the decoder and the worker pool, it is shared by Start, the Serve* methods and WebsocketHandler
+// (初始化解码器和worker工作池，由Start、Serve*方法和WebsocketHandler共用，只执行一次)
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		// Add decoder to interceptors head
+		// (将解码器添加到拦截器最前面)
+		if s.decoder != nil {
+			s.msgHandler.SetHeadInterceptor(s.decoder)
+		}
+		// Start worker pool mechanism
+		// (启动worker工作池机制)
+		s.msgHandler.StartWorkerPool()
 
-	// Start a goroutine to handle server listener business
-	// (开启一个go去做服务端Listener业务)
-	switch gconf.GlobalObject.Mode {
+		// Watch the TLS certificate for changes if configured (配置了证书时监听证书的变化)
+		if s.useTLS() {
+			s.watchCertificate()
+		}
+
+		// Start the admin endpoints if configured (配置了管理监听时启动管理接口)
+		if s.config.AdminAddr != "" {
+			go s.listenAdmin(s.config.AdminAddr)
+		}
+		// Start the metrics listener if configured (配置了指标监听时启动指标接口)
+		if s.config.MetricsAddr != "" {
+			go s.listenMetrics(s.config.MetricsAddr, s.config.MetricsPath)
+		}
+	})
+}
+
+// addAddr records the address a listener of mode is bound to (记录某传输协议监听实际绑定的地址)
+func (s *Server) addAddr(mode string, addr net.Addr) {
+	s.addrLock.Lock()
+	defer s.addrLock.Unlock()
+
+	if s.addrs == nil {
+		s.addrs = make(map[string][]net.Addr)
+	}
+	s.addrs[mode] = append(s.addrs[mode], addr)
+}
+
+// addr returns the address of the first listener of mode, nil if none is listening yet
+// (返回该传输协议第一个监听实际绑定的地址，尚未监听时返回nil)
+func (s *Server) addr(mode string) net.Addr {
+	s.addrLock.RLock()
+	defer s.addrLock.RUnlock()
+
+	if addrs := s.addrs[mode]; len(addrs) > 0 {
+		return addrs[0]
+	}
+	return nil
+}
+
+// TcpAddr returns the bound address of the first TCP listener, nil if none is listening yet
+// (返回第一个TCP监听实际绑定的地址，尚未监听时返回nil)
+func (s *Server) TcpAddr() net.Addr {
+	return s.addr(gconf.ServerModeTcp)
+}
+
+// WsAddr returns the bound address of the first websocket listener, nil if none is listening yet
+// (返回第一个websocket监听实际绑定的地址，尚未监听时返回nil)
+func (s *Server) WsAddr() net.Addr {
+	return s.addr(gconf.ServerModeWebSocket)
+}
+
+// KcpAddr returns the bound address of the first KCP listener, nil if none is listening yet
+// (返回第一个KCP监听实际绑定的地址，尚未监听时返回nil)
+func (s *Server) KcpAddr() net.Addr {
+	return s.addr(gconf.ServerModeKcp)
+}
+
+// UnixAddr returns the bound address of the first unix listener, nil if none is listening yet
+// (返回第一个unix监听实际绑定的地址，尚未监听时返回nil)
+func (s *Server) UnixAddr() net.Addr {
+	return s.addr(gconf.ServerModeUnix)
+}
+
+// startListener starts the listener goroutine of the given transport
+// (按传输协议启动对应的监听协程)
+func (s *Server) startListener(l gconf.ListenerConfig) {
+	host := l.Host
+	if host == "" {
+		host = s.IP
+	}
+
+	switch l.Mode {
 	case gconf.ServerModeTcp:
-		go s.ListenTcpConn()
+		go s.listenTcpConn(host, l.Port, l.ProxyProtocol, l.ReusePort)
 	case gconf.ServerModeWebSocket:
-		go s.ListenWebsocketConn()
+		go s.listenWebsocketConn(host, l.Port, l.Path, l.ProxyProtocol)
 	case gconf.ServerModeKcp:
-		go s.ListenKcpConn()
+		go s.listenKcpConn(host, l.Port)
+	case gconf.ServerModeUnix:
+		go s.listenUnixConn(l.Path)
 	default:
-		go s.ListenTcpConn()
-		go s.ListenWebsocketConn()
+		glog.Ins().ErrorF("[START] unknown listener mode: %s, Host: %s, Port: %d", l.Mode, host, l.Port)
 	}
+}
 
+// AddListener adds a listener to the server, it must be called before Start.
+// For the "unix" mode host is the socket path and port is ignored.
+// (为服务器添加一个监听，需要在Start之前调用，"unix"模式下host为socket路径，port被忽略)
+func (s *Server) AddListener(mode string, host string, port int) {
+	if mode == gconf.ServerModeUnix {
+		s.Listeners = append(s.Listeners, gconf.ListenerConfig{Mode: mode, Path: host})
+		return
+	}
+	s.Listeners = append(s.Listeners, gconf.ListenerConfig{Mode: mode, Host: host, Port: port})
 }
 
 // Stop stops the server (停止服务)
@@ -409,8 +981,35 @@ func (s *Server) Stop() {
 	// Clear other connection information or other information that needs to be cleaned up
 	// (将其他需要清理的连接信息或者其他信息 也要一并停止或者清理)
 	s.ConnMgr.ClearConn()
-	s.exitChan <- struct{}{}
-	close(s.exitChan)
+	s.closeListeners()
+	s.closeKcpListeners()
+}
+
+// isStopping reports whether the server has been asked to stop
+// (服务是否已经开始停止)
+func (s *Server) isStopping() bool {
+	select {
+	case <-s.exitChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeListeners notifies every listener goroutine to stop accepting, the KCP listeners are closed by closeKcpListeners
+// (通知所有监听协程停止接收新连接，KCP监听由closeKcpListeners关闭)
+func (s *Server) closeListeners() {
+	s.exitOnce.Do(func() {
+		close(s.exitChan)
+	})
+}
+
+// closeKcpListeners notifies every KCP listener goroutine to close its listener and packet conn
+// (通知所有KCP监听协程关闭监听及其PacketConn)
+func (s *Server) closeKcpListeners() {
+	s.kcpOnce.Do(func() {
+		close(s.kcpClose)
+	})
 }
 
 // Serve runs the server (运行服务)
@@ -452,10 +1051,21 @@ func (s *Server) Use(Handlers ...giface.RouterHandler) giface.IRouterSlices {
 	return s.msgHandler.Use(Handlers...)
 }
 
+// SetHandlerTimeout sets the deadline of the request context in the handlers of msgID, overriding
+// the HandlerTimeout of the config, a negative timeout removes the deadline. It must be called before Start.
+// (设置msgID处理函数中请求context的截止时间，覆盖配置中的HandlerTimeout，负数表示不限制，需要在Start之前调用)
+func (s *Server) SetHandlerTimeout(msgID uint32, timeout time.Duration) {
+	s.msgHandler.SetHandlerTimeout(msgID, timeout)
+}
+
 func (s *Server) GetConnMgr() giface.IConnManager {
 	return s.ConnMgr
 }
 
+func (s *Server) GetGroupMgr() giface.IGroupManager {
+	return s.GroupMgr
+}
+
 func (s *Server) SetOnConnStart(hookFunc func(giface.IConnection)) {
 	s.onConnStart = hookFunc
 }
@@ -464,6 +1074,31 @@ func (s *Server) SetOnConnStop(hookFunc func(giface.IConnection)) {
 	s.onConnStop = hookFunc
 }
 
+// GetSessionMgr returns the sessions of the server, nil if gconf.Config.SessionGracePeriod is not set
+// (返回服务器的会话管理，未设置gconf.Config.SessionGracePeriod时为nil)
+func (s *Server) GetSessionMgr() giface.ISessionManager {
+	if s.sessions == nil {
+		return nil
+	}
+	return s.sessions
+}
+
+// onConnStartOf returns the hook run when a connection of server starts, the sessions of a Server are opened
+// before its OnConnStart hook
+// (返回server的连接建立时执行的钩子，Server的会话在其OnConnStart钩子之前打开)
+func onConnStartOf(server giface.IServer) func(giface.IConnection) {
+	s, ok := server.(*Server)
+	if !ok || s.sessions == nil {
+		return server.GetOnConnStart()
+	}
+	return func(conn giface.IConnection) {
+		s.sessions.open(conn)
+		if s.onConnStart != nil {
+			s.onConnStart(conn)
+		}
+	}
+}
+
 func (s *Server) GetOnConnStart() func(giface.IConnection) {
 	return s.onConnStart
 }
@@ -480,6 +1115,31 @@ func (s *Server) SetPacket(packet giface.IDataPack) {
 	s.packet = packet
 }
 
+// SetConnIDGenerator replaces the auto-increment connection IDs, it must be called before Start
+// (替换自增的连接ID，需要在Start之前调用)
+func (s *Server) SetConnIDGenerator(gen giface.IConnIDGenerator) {
+	s.connIDGen = gen
+}
+
+// GetConnIDGenerator returns the connection ID generator, nil if the auto-increment IDs are used
+// (返回连接ID生成器，使用自增ID时返回nil)
+func (s *Server) GetConnIDGenerator() giface.IConnIDGenerator {
+	return s.connIDGen
+}
+
+// nextConnID returns the ID of a new connection (返回新连接的ID)
+func (s *Server) nextConnID() uint64 {
+	if s.connIDGen != nil {
+		return s.connIDGen.NextID()
+	}
+	return atomic.AddUint64(&s.cID, 1)
+}
+
+// GetConfig returns the config of the server (返回服务器的配置)
+func (s *Server) GetConfig() *gconf.Config {
+	return s.config
+}
+
 func (s *Server) GetMsgHandler() giface.IMsgHandler {
 	return s.msgHandler
 }
@@ -558,6 +1218,16 @@ func (s *Server) SetWebsocketAuth(f func(r *http.Request) error) {
 	s.websocketAuth = f
 }
 
+// WebsocketHandler returns the http.Handler that upgrades requests to websocket connections of this server.
+// It can be mounted on any path of an existing HTTP server, it starts the worker pool so Start does not need
+// to be called, Stop or Shutdown still closes the connections.
+// (返回将请求升级为本服务websocket连接的http.Handler，可以挂载到已有HTTP服务的任意路径。它会启动worker工作池，
+// 因此无需调用Start，连接仍由Stop或Shutdown关闭)
+func (s *Server) WebsocketHandler() http.Handler {
+	s.init()
+	return http.HandlerFunc(s.serveWebsocket)
+}
+
 func (s *Server) ServerName() string {
 	return s.Name
 }
@@ -0,0 +1,96 @@
+package gnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/gpack"
+)
+
+type notifyRouter struct {
+	BaseRouter
+	handled chan struct{}
+}
+
+func (r *notifyRouter) Handle(request giface.IRequest) {
+	r.handled <- struct{}{}
+}
+
+func TestWebsocketHandlerWithoutStart(t *testing.T) {
+	const clients = 4
+
+	s := NewUserConfServer(&gconf.Config{WorkerPoolSize: 2, MaxConn: clients}).(*Server)
+	router := &notifyRouter{handled: make(chan struct{}, clients)}
+	s.AddRouter(1, router)
+	// Mounted on an HTTP server of the caller, Start is never called (挂载到调用方的HTTP服务上，不调用Start)
+	httpServer := httptest.NewServer(s.WebsocketHandler())
+	defer httpServer.Close()
+	defer s.Stop()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	msg, err := s.GetPacket().Pack(gpack.NewMsgPackage(1, []byte("ping")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The subprotocols of concurrent upgrades must not leak into each other (并发升级的子协议不能互相影响)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(protocol string) {
+			defer wg.Done()
+			dialer := websocket.Dialer{Subprotocols: []string{protocol}}
+			conn, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Errorf("Dial() err = %v", err)
+				return
+			}
+			defer conn.Close()
+			if conn.Subprotocol() != protocol {
+				t.Errorf("Subprotocol() = %q, want %q", conn.Subprotocol(), protocol)
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				t.Errorf("WriteMessage() err = %v", err)
+				return
+			}
+			select {
+			case <-router.handled:
+			case <-time.After(5 * time.Second):
+				t.Error("the message is not handled by the worker pool")
+			}
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+}
+
+func TestWebsocketHandlerRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(s *Server)
+		want  int
+	}{
+		{name: "max conn", setup: func(s *Server) { s.ConnMgr.Add(&fakeConn{id: 1}) }, want: http.StatusServiceUnavailable},
+		{name: "stopping", setup: func(s *Server) { s.closeListeners() }, want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUserConfServer(&gconf.Config{MaxConn: 1}).(*Server)
+			tt.setup(s)
+			AcceptDelay.Reset()
+
+			rec := httptest.NewRecorder()
+			s.WebsocketHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
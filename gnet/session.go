@@ -0,0 +1,316 @@
+package gnet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+	"github.com/liyee/gray/glog"
+)
+
+// SessionManager keeps the sessions of a server, it is created when gconf.Config.SessionGracePeriod is set.
+// A session is opened when a connection starts and its token is sent on giface.SessionTokenMsgID. When the
+// connection closes, the properties of the connection are kept in the session for the grace period, messages
+// sent to the session meanwhile are buffered, and both are re-attached to the connection that presents the
+// token on giface.SessionResumeMsgID.
+// (SessionManager管理服务器的会话，设置gconf.Config.SessionGracePeriod时创建。连接建立时打开一个会话，并通过
+// giface.SessionTokenMsgID发送其令牌。连接关闭后，会话在宽限期内保留连接的属性并缓存期间发给会话的消息，
+// 在giface.SessionResumeMsgID上出示令牌的连接会重新绑定这些属性和消息)
+type SessionManager struct {
+	grace     time.Duration
+	replayLen int
+
+	lock     sync.Mutex
+	sessions map[string]*session             // the sessions keyed by token (按令牌保存的会话)
+	byConn   map[giface.IConnection]*session // the session attached to each connection (每个连接绑定的会话)
+}
+
+type session struct {
+	token     string
+	replayLen int
+
+	lock       sync.Mutex
+	conn       giface.IConnection     // nil while detached (断开期间为nil)
+	properties map[string]interface{} // the properties of the connection kept while detached (断开期间保留的连接属性)
+	replay     []replayMsg            // the messages sent while detached (断开期间发送的消息)
+	expire     *time.Timer
+	expired    bool
+}
+
+type replayMsg struct {
+	msgID uint32
+	data  []byte
+}
+
+func newSessionManager(config *gconf.Config) *SessionManager {
+	return &SessionManager{
+		grace:     config.SessionGracePeriodDuration(),
+		replayLen: config.SessionReplayLen,
+		sessions:  make(map[string]*session),
+		byConn:    make(map[giface.IConnection]*session),
+	}
+}
+
+func (sm *SessionManager) Get(token string) (giface.ISession, error) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if s, ok := sm.sessions[token]; ok {
+		return s, nil
+	}
+	return nil, errors.New("session not found")
+}
+
+func (sm *SessionManager) SessionOf(conn giface.IConnection) (giface.ISession, error) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if s, ok := sm.byConn[conn]; ok {
+		return s, nil
+	}
+	return nil, errors.New("session not found")
+}
+
+func (sm *SessionManager) Len() int {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	return len(sm.sessions)
+}
+
+// open opens a new session for conn and sends its token, it runs before the OnConnStart hook
+// (为连接打开一个新会话并发送令牌，在OnConnStart钩子之前执行)
+func (sm *SessionManager) open(conn giface.IConnection) {
+	token, err := newSessionToken()
+	if err != nil {
+		glog.Ins().ErrorF("ConnID=%d new session token err: %v", conn.GetConnID(), err)
+		return
+	}
+	s := &session{token: token, replayLen: sm.replayLen, conn: conn}
+
+	sm.lock.Lock()
+	sm.sessions[token] = s
+	sm.byConn[conn] = s
+	sm.lock.Unlock()
+
+	conn.AddCloseCallback(sm, nil, func() {
+		sm.detach(conn)
+	})
+
+	if err := conn.SendMsg(giface.SessionTokenMsgID, []byte(token)); err != nil {
+		glog.Ins().ErrorF("ConnID=%d send session token err: %v", conn.GetConnID(), err)
+	}
+}
+
+// detach keeps the session of a closed connection for the grace period (连接关闭后在宽限期内保留其会话)
+func (sm *SessionManager) detach(conn giface.IConnection) {
+	sm.lock.Lock()
+	s, ok := sm.byConn[conn]
+	delete(sm.byConn, conn)
+	sm.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	s.takeProperties(conn)
+	s.conn = nil
+	s.expire = time.AfterFunc(sm.grace, func() {
+		sm.expire(s)
+	})
+	s.lock.Unlock()
+}
+
+func (sm *SessionManager) expire(s *session) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The session may have been resumed while the timer fired (定时器触发时会话可能刚被恢复)
+	if s.conn != nil || s.expired {
+		return
+	}
+	s.expired = true
+	s.replay = nil
+	delete(sm.sessions, s.token)
+	glog.Ins().DebugF("session %s expired", s.token)
+}
+
+// resume attaches the session of token to conn in place of the session opened for conn, a session still
+// attached to another connection is taken over and that connection is stopped. It returns the token of the
+// session of conn afterwards, which is token if it was resumed.
+// (将token对应的会话绑定到conn，替换为conn新打开的会话。若该会话仍绑定在其他连接上，则接管会话并停止那个连接。
+// 返回之后conn所绑定会话的令牌，恢复成功时即为token)
+func (sm *SessionManager) resume(conn giface.IConnection, token string) string {
+	sm.lock.Lock()
+
+	current := sm.byConn[conn]
+	s, ok := sm.sessions[token]
+	if !ok || s == current {
+		sm.lock.Unlock()
+		if current == nil {
+			return ""
+		}
+		return current.token
+	}
+
+	s.lock.Lock()
+	old := s.conn
+	if old != nil {
+		// The server has not noticed yet that the old connection is gone (服务端尚未发现旧连接已断开)
+		delete(sm.byConn, old)
+		s.takeProperties(old)
+	}
+	if s.expire != nil {
+		s.expire.Stop()
+		s.expire = nil
+	}
+	s.conn = conn
+	properties, replay := s.properties, s.replay
+	s.properties, s.replay = nil, nil
+
+	sm.byConn[conn] = s
+	if current != nil {
+		delete(sm.sessions, current.token)
+	}
+	sm.lock.Unlock()
+
+	for k, v := range properties {
+		conn.SetProperty(k, v)
+	}
+	// The replayed messages are queued while s.lock is held, so they keep their order before the messages sent
+	// through the session afterwards and the token confirmation. Queuing only waits for a full queue for a while,
+	// so a stalled connection does not hold s.lock for long.
+	// (持有s.lock时将重放的消息放入发送队列，使其先于之后通过会话发送的消息及令牌确认。入队只会在队列已满时等待
+	// 一小段时间，因此停滞的连接不会长时间占用s.lock)
+	for _, msg := range replay {
+		if err := conn.SendBuffMsg(msg.msgID, msg.data); err != nil {
+			glog.Ins().ErrorF("ConnID=%d replay msgID = %d err: %v", conn.GetConnID(), msg.msgID, err)
+		}
+	}
+	s.lock.Unlock()
+
+	if old != nil {
+		glog.Ins().InfoF("session %s is resumed by ConnID=%d, stop ConnID=%d", token, conn.GetConnID(), old.GetConnID())
+		old.Stop()
+	}
+
+	glog.Ins().InfoF("session %s resumed by ConnID=%d, %d messages replayed", token, conn.GetConnID(), len(replay))
+	return token
+}
+
+// takeProperties keeps the properties of conn, s.lock must be held (保留连接的属性，调用方需持有s.lock)
+func (s *session) takeProperties(conn giface.IConnection) {
+	if c, ok := conn.(inspectable); ok {
+		s.properties = c.GetProperties()
+	}
+}
+
+func (s *session) GetToken() string {
+	return s.token
+}
+
+func (s *session) Conn() giface.IConnection {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.conn
+}
+
+func (s *session) SendBuffMsg(msgID uint32, data []byte) error {
+	s.lock.Lock()
+	conn := s.conn
+	if conn != nil {
+		s.lock.Unlock()
+		return conn.SendBuffMsg(msgID, data)
+	}
+	defer s.lock.Unlock()
+
+	if s.expired {
+		return errors.New("session expired")
+	}
+	if s.replayLen <= 0 {
+		return errors.New("session detached")
+	}
+	if len(s.replay) >= s.replayLen {
+		// Keep the latest messages (保留最新的消息)
+		glog.Ins().DebugF("session %s replay buffer is full, drop msgID = %d", s.token, s.replay[0].msgID)
+		s.replay = s.replay[1:]
+	}
+	s.replay = append(s.replay, replayMsg{msgID: msgID, data: append([]byte(nil), data...)})
+	return nil
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sessionResumeRouter handles giface.SessionResumeMsgID on the server (服务端处理giface.SessionResumeMsgID)
+type sessionResumeRouter struct {
+	BaseRouter
+	sessions *SessionManager
+}
+
+func (r *sessionResumeRouter) Handle(request giface.IRequest) {
+	conn := request.GetConnection()
+	token := r.sessions.resume(conn, string(request.GetData()))
+	if token == "" {
+		return
+	}
+	// Queued after the replayed messages (排在重放的消息之后)
+	if err := conn.SendBuffMsg(giface.SessionTokenMsgID, []byte(token)); err != nil {
+		glog.Ins().ErrorF("ConnID=%d send session token err: %v", conn.GetConnID(), err)
+	}
+}
+
+// clientSession keeps the session token of a client across its connections (在客户端的多个连接间保留会话令牌)
+type clientSession struct {
+	lock       sync.Mutex
+	token      string
+	resumeConn giface.IConnection // the connection the resume request was sent on (发送了恢复请求的连接)
+}
+
+// sessionTokenRouter handles giface.SessionTokenMsgID on the client. When a new connection receives a token other
+// than the one kept, it asks to resume the kept session, the token received afterwards is the session to use.
+// (客户端处理giface.SessionTokenMsgID。新连接收到与已保留令牌不同的令牌时，请求恢复已保留的会话，之后收到的令牌即为要使用的会话)
+type sessionTokenRouter struct {
+	BaseRouter
+	session *clientSession
+}
+
+func (r *sessionTokenRouter) Handle(request giface.IRequest) {
+	conn := request.GetConnection()
+	token := string(request.GetData())
+
+	r.session.lock.Lock()
+	if kept := r.session.token; kept != "" && kept != token && r.session.resumeConn != conn {
+		r.session.resumeConn = conn
+		r.session.lock.Unlock()
+		if err := conn.SendMsg(giface.SessionResumeMsgID, []byte(kept)); err != nil {
+			glog.Ins().ErrorF("send session resume err: %v", err)
+		}
+		return
+	}
+	if r.session.token != "" && r.session.token != token {
+		glog.Ins().InfoF("session %s could not be resumed, new session %s", r.session.token, token)
+	}
+	r.session.token = token
+	r.session.lock.Unlock()
+}
+
+func (cs *clientSession) getToken() string {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	return cs.token
+}
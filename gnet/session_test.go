@@ -0,0 +1,209 @@
+package gnet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+)
+
+// sessionTestConn records the messages sent on it in order, those written directly with SendMsg are prefixed
+// (按顺序记录发送的消息，通过SendMsg直接写出的消息带有前缀)
+type sessionTestConn struct {
+	giface.IConnection
+	lock       sync.Mutex
+	sent       []string
+	properties map[string]interface{}
+	stopped    bool
+}
+
+func newSessionTestConn() *sessionTestConn {
+	return &sessionTestConn{properties: make(map[string]interface{})}
+}
+
+func (c *sessionTestConn) GetConnID() uint64                                   { return 0 }
+func (c *sessionTestConn) AddCloseCallback(handler, key interface{}, f func()) {}
+
+func (c *sessionTestConn) send(msgID uint32, data []byte, direct bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	msg := string(data)
+	if msgID == giface.SessionTokenMsgID {
+		msg = "token"
+	} else if direct {
+		msg = "direct:" + msg
+	}
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func (c *sessionTestConn) SendMsg(msgID uint32, data []byte) error { return c.send(msgID, data, true) }
+func (c *sessionTestConn) SendBuffMsg(msgID uint32, data []byte) error {
+	return c.send(msgID, data, false)
+}
+
+func (c *sessionTestConn) messages() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string(nil), c.sent...)
+}
+
+func (c *sessionTestConn) SetProperty(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.properties[key] = value
+}
+
+func (c *sessionTestConn) GetProperties() map[string]interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	properties := make(map[string]interface{}, len(c.properties))
+	for k, v := range c.properties {
+		properties[k] = v
+	}
+	return properties
+}
+
+func (c *sessionTestConn) LastActivity() time.Time  { return time.Time{} }
+func (c *sessionTestConn) SendQueueLen() (int, int) { return 0, 0 }
+func (c *sessionTestConn) Stop()                    { c.lock.Lock(); c.stopped = true; c.lock.Unlock() }
+func (c *sessionTestConn) isStopped() bool          { c.lock.Lock(); defer c.lock.Unlock(); return c.stopped }
+
+func sessionTokenOf(t *testing.T, sm *SessionManager, conn giface.IConnection) string {
+	s, err := sm.SessionOf(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.GetToken()
+}
+
+func TestSessionReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		replayLen int
+		detached  []string // the messages sent while detached (断开期间发送的消息)
+		wantErr   bool
+		want      []string // the messages of the resumed connection after its own token (恢复的连接在其自身令牌之后收到的消息)
+	}{
+		{name: "in order", replayLen: 3, detached: []string{"a", "b"}, want: []string{"a", "b", "after"}},
+		{name: "latest kept", replayLen: 3, detached: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e", "after"}},
+		{name: "no replay", replayLen: -1, detached: []string{"a"}, wantErr: true, want: []string{"after"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := newSessionManager(&gconf.Config{SessionGracePeriod: 60, SessionReplayLen: tt.replayLen})
+			old := newSessionTestConn()
+			sm.open(old)
+			token := sessionTokenOf(t, sm, old)
+			old.SetProperty("user", "u1")
+			sm.detach(old)
+
+			s, err := sm.Get(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Conn() != nil {
+				t.Fatal("Conn() of a detached session is not nil")
+			}
+			for _, msg := range tt.detached {
+				if err := s.SendBuffMsg(1, []byte(msg)); (err != nil) != tt.wantErr {
+					t.Fatalf("SendBuffMsg() while detached err = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+
+			conn := newSessionTestConn()
+			sm.open(conn)
+			if got := sm.resume(conn, token); got != token {
+				t.Fatalf("resume() = %q, want %q", got, token)
+			}
+			if err := s.SendBuffMsg(1, []byte("after")); err != nil {
+				t.Fatal(err)
+			}
+
+			got := conn.messages()[1:]
+			if len(got) != len(tt.want) {
+				t.Fatalf("messages = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("messages = %v, want %v", got, tt.want)
+				}
+			}
+			if s.Conn() != giface.IConnection(conn) {
+				t.Fatal("the session is not attached to the resumed connection")
+			}
+			if conn.GetProperties()["user"] != "u1" {
+				t.Fatal("the properties are not restored")
+			}
+			// The session opened for the new connection is replaced (新连接打开的会话被替换)
+			if sm.Len() != 1 {
+				t.Fatalf("Len() = %d, want 1", sm.Len())
+			}
+		})
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	sm := newSessionManager(&gconf.Config{SessionReplayLen: 3})
+	sm.grace = 20 * time.Millisecond
+	old := newSessionTestConn()
+	sm.open(old)
+	token := sessionTokenOf(t, sm, old)
+	s, _ := sm.Get(token)
+	sm.detach(old)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := sm.Get(token); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the session did not expire after the grace period")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := s.SendBuffMsg(1, nil); err == nil {
+		t.Fatal("SendBuffMsg() on an expired session err = nil")
+	}
+	conn := newSessionTestConn()
+	sm.open(conn)
+	if got := sm.resume(conn, token); got == token || got != sessionTokenOf(t, sm, conn) {
+		t.Fatalf("resume() of an expired session = %q, want the token of the new session", got)
+	}
+}
+
+func TestSessionTakeover(t *testing.T) {
+	sm := newSessionManager(&gconf.Config{SessionGracePeriod: 60, SessionReplayLen: 3})
+	// The server has not noticed yet that the old connection is gone (服务端尚未发现旧连接已断开)
+	old := newSessionTestConn()
+	sm.open(old)
+	token := sessionTokenOf(t, sm, old)
+	old.SetProperty("user", "u1")
+
+	conn := newSessionTestConn()
+	sm.open(conn)
+	if got := sm.resume(conn, token); got != token {
+		t.Fatalf("resume() = %q, want %q", got, token)
+	}
+
+	if !old.isStopped() {
+		t.Fatal("the connection still attached is not stopped")
+	}
+	if _, err := sm.SessionOf(old); err == nil {
+		t.Fatal("SessionOf() the old connection err = nil")
+	}
+	if got := sessionTokenOf(t, sm, conn); got != token {
+		t.Fatalf("SessionOf() the new connection = %q, want %q", got, token)
+	}
+	if conn.GetProperties()["user"] != "u1" {
+		t.Fatal("the properties are not taken over")
+	}
+}
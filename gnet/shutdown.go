@@ -0,0 +1,108 @@
+package gnet
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/liyee/gray/glog"
+)
+
+// The polling interval used while waiting for workers, send queues and connections to drain
+// (优雅关闭时轮询等待的时间间隔)
+const shutdownPollInterval = 10 * time.Millisecond
+
+// sendQueueDrainer is implemented by connections that can wait for their SendBuffMsg queue to be flushed
+// (可以等待SendBuffMsg发送队列清空的连接)
+type sendQueueDrainer interface {
+	drainSendQueue(ctx context.Context) error
+}
+
+// waitUntil polls done until it returns true or ctx expires
+// (轮询done直到其返回true或ctx到期)
+func waitUntil(ctx context.Context, done func() bool) error {
+	if done() {
+		return nil
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if done() {
+				return nil
+			}
+		}
+	}
+}
+
+// Shutdown gracefully stops the server. It stops accepting on all listeners, waits for the
+// requests already handed to the workers to finish, flushes the SendBuffMsg queue of every
+// connection and finally closes the connections.
+// If ctx expires first, the remaining connections are closed immediately and ctx.Err() is returned.
+// (优雅关闭服务：停止所有监听，等待已交给worker的请求处理完毕，清空每个连接的发送队列，最后关闭连接。
+// 如果ctx先到期，则立即关闭剩余连接并返回ctx.Err())
+func (s *Server) Shutdown(ctx context.Context) error {
+	glog.Ins().InfoF("[SHUTDOWN] Gray server , name %s", s.Name)
+
+	// 1. Stop accepting new connections, the KCP listeners are closed last since closing them kills their sessions
+	// (停止接收新连接，KCP监听最后关闭，因为关闭它会断开其上的会话)
+	s.closeListeners()
+	defer s.closeKcpListeners()
+
+	// 2. Wait for in-flight requests to be handled (等待正在处理的请求完成)
+	if err := s.msgHandler.Drain(ctx); err != nil {
+		glog.Ins().ErrorF("[SHUTDOWN] drain workers err: %v", err)
+		s.ConnMgr.ClearConn()
+		return err
+	}
+
+	// 3. Flush the send queue of every connection (清空每个连接的发送队列)
+	for _, connID := range s.ConnMgr.GetAllConnIDStr() {
+		conn, err := s.ConnMgr.Get2(connID)
+		if err != nil {
+			continue
+		}
+		drainer, ok := conn.(sendQueueDrainer)
+		if !ok {
+			continue
+		}
+		if err = drainer.drainSendQueue(ctx); err != nil {
+			glog.Ins().ErrorF("[SHUTDOWN] flush send queue connID = %s err: %v", connID, err)
+			s.ConnMgr.ClearConn()
+			return err
+		}
+	}
+
+	// 4. Close all connections and wait until they are removed from the ConnManager
+	// (关闭所有连接，并等待它们从ConnManager中移除)
+	s.ConnMgr.ClearConn()
+
+	return waitUntil(ctx, func() bool {
+		return s.ConnMgr.Len() == 0
+	})
+}
+
+// drainSendQueue waits until the buffered messages of the connection have been written
+// (等待连接缓冲队列中的消息全部写出)
+func (c *Connection) drainSendQueue(ctx context.Context) error {
+	return waitUntil(ctx, func() bool {
+		return c.isClosed() || atomic.LoadInt64(&c.pendingBuffMsg) <= 0
+	})
+}
+
+func (c *WsConnection) drainSendQueue(ctx context.Context) error {
+	return waitUntil(ctx, func() bool {
+		return c.isClosed() || atomic.LoadInt64(&c.pendingBuffMsg) <= 0
+	})
+}
+
+func (c *KcpConnection) drainSendQueue(ctx context.Context) error {
+	return waitUntil(ctx, func() bool {
+		return c.isClosed() || atomic.LoadInt64(&c.pendingBuffMsg) <= 0
+	})
+}
@@ -0,0 +1,211 @@
+package gnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+	"github.com/liyee/gray/giface"
+)
+
+const (
+	shutdownTestReqMsgID  uint32 = 1
+	shutdownTestPushMsgID uint32 = 2
+	shutdownTestPushes           = 200
+)
+
+// shutdownTestRouter answers a request with shutdownTestPushes buffered messages
+type shutdownTestRouter struct {
+	BaseRouter
+	handled chan struct{}
+	once    sync.Once
+}
+
+func (r *shutdownTestRouter) Handle(request giface.IRequest) {
+	conn := request.GetConnection()
+	for i := 0; i < shutdownTestPushes; i++ {
+		if err := conn.SendBuffMsg(shutdownTestPushMsgID, []byte{byte(i)}); err != nil {
+			panic(err)
+		}
+	}
+	r.once.Do(func() { close(r.handled) })
+}
+
+type countRouter struct {
+	BaseRouter
+	count int64
+}
+
+func (r *countRouter) Handle(request giface.IRequest) {
+	atomic.AddInt64(&r.count, 1)
+}
+
+func TestShutdownFlushesSendQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		serve     func(t *testing.T, s *Server) int
+		newClient func(ip string, port int, opts ...ClientOption) giface.IClient
+	}{
+		{
+			name: "tcp",
+			serve: func(t *testing.T, s *Server) int {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go s.ServeListener(listener)
+				return listener.Addr().(*net.TCPAddr).Port
+			},
+			newClient: NewClient,
+		},
+		{
+			name: "websocket",
+			serve: func(t *testing.T, s *Server) int {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go s.ServeWebsocketListener(listener, "/")
+				return listener.Addr().(*net.TCPAddr).Port
+			},
+			newClient: NewWsClient,
+		},
+		{
+			name: "kcp",
+			serve: func(t *testing.T, s *Server) int {
+				conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go s.ServeKcpPacketConn(conn)
+				return conn.LocalAddr().(*net.UDPAddr).Port
+			},
+			newClient: NewKcpClient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUserConfServer(&gconf.Config{Name: "shutdown-" + tt.name}).(*Server)
+			router := &shutdownTestRouter{handled: make(chan struct{})}
+			s.AddRouter(shutdownTestReqMsgID, router)
+			port := tt.serve(t, s)
+
+			counter := &countRouter{}
+			client := tt.newClient("127.0.0.1", port)
+			client.AddRouter(shutdownTestPushMsgID, counter)
+			client.SetOnConnStart(func(conn giface.IConnection) {
+				_ = conn.SendMsg(shutdownTestReqMsgID, nil)
+			})
+			client.Start()
+			defer client.Stop()
+
+			select {
+			case <-router.handled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("request not handled")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown() err = %v", err)
+			}
+
+			err := waitUntil(ctx, func() bool {
+				return atomic.LoadInt64(&counter.count) == shutdownTestPushes
+			})
+			if err != nil {
+				t.Fatalf("client received %d of %d messages", atomic.LoadInt64(&counter.count), shutdownTestPushes)
+			}
+		})
+	}
+}
+
+func TestStopUnstartedServer(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(s *Server) error
+	}{
+		{name: "stop", stop: func(s *Server) error { s.Stop(); return nil }},
+		{name: "shutdown", stop: func(s *Server) error { return s.Shutdown(context.Background()) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUserConfServer(&gconf.Config{}).(*Server)
+			if err := tt.stop(s); err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !s.isStopping() {
+				t.Fatal("the server is not stopping")
+			}
+		})
+	}
+}
+
+func TestConnStoppedBeforeStart(t *testing.T) {
+	s := NewUserConfServer(&gconf.Config{}).(*Server)
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	conn := newServerConn(s, local, 1)
+	closed := make(chan struct{})
+	conn.AddCloseCallback(nil, nil, func() { close(closed) })
+	if s.ConnMgr.Len() != 1 {
+		t.Fatalf("ConnMgr.Len() = %d, want 1", s.ConnMgr.Len())
+	}
+	// ClearConn stops the connections accepted but not started yet (ClearConn停止已接受但尚未启动的连接)
+	s.ConnMgr.ClearConn()
+
+	started := make(chan struct{})
+	go func() {
+		conn.Start()
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() serves a connection stopped before it was started")
+	}
+	if s.ConnMgr.Len() != 0 {
+		t.Fatalf("ConnMgr.Len() = %d, want 0", s.ConnMgr.Len())
+	}
+	if _, err := remote.Write([]byte{0}); err == nil {
+		t.Fatal("the socket of the stopped connection is open")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the close callbacks of a connection stopped before it was started are not run")
+	}
+}
+
+func TestMsgHandlerDrainAcquire(t *testing.T) {
+	mh := newMsgHandler(&gconf.Config{})
+
+	if !mh.acquire() {
+		t.Fatal("acquire() before Drain = false")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := mh.Drain(ctx); err == nil {
+		t.Fatal("Drain() returns with a request pending")
+	}
+	if mh.acquire() {
+		t.Fatal("acquire() while draining = true")
+	}
+	if pending := atomic.LoadInt64(&mh.pending); pending != 1 {
+		t.Fatalf("pending = %d, want 1", pending)
+	}
+
+	atomic.AddInt64(&mh.pending, -1)
+	if err := mh.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() err = %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package gnet
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTcpUserTimeout sets TCP_USER_TIMEOUT, the time sent data may stay unacknowledged before the kernel drops the connection
+// (设置TCP_USER_TIMEOUT，即已发送数据未被确认的最长时间，超过后内核断开连接)
+func setTcpUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(timeout.Milliseconds()))
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
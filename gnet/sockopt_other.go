@@ -0,0 +1,14 @@
+//go:build !linux
+
+package gnet
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// setTcpUserTimeout is only implemented on Linux (仅在Linux下实现)
+func setTcpUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	return errors.New("TCP_USER_TIMEOUT is only supported on linux")
+}
@@ -0,0 +1,114 @@
+package gnet
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+)
+
+// TcpConfig holds the socket options of tcp connections, 0 keeps the default of Go and the operating system
+// (tcp连接的socket选项，0表示使用Go和操作系统的默认值)
+type TcpConfig struct {
+	// TCP_NODELAY, 1 sends small packets at once (Go default), -1 enables Nagle's algorithm.
+	// (1立即发送小包(Go默认)，-1启用Nagle算法)
+	TcpNoDelay int
+	// The keepalive period in seconds, -1 disables keepalive.
+	// (TCP keepalive探测间隔，单位秒，-1关闭keepalive)
+	TcpKeepAlive int
+	// SO_RCVBUF in bytes.
+	// (接收缓冲区大小，单位字节)
+	TcpReadBuffer int
+	// SO_SNDBUF in bytes.
+	// (发送缓冲区大小，单位字节)
+	TcpWriteBuffer int
+	// SO_LINGER in seconds, -1 resets the connection on close discarding unsent data.
+	// (关闭时等待未发送数据的时间，单位秒，-1关闭时直接重置连接并丢弃未发送数据)
+	TcpLinger int
+	// TCP_USER_TIMEOUT in milliseconds, how long sent data may stay unacknowledged before the connection is dropped, Linux only.
+	// (已发送数据未被确认的最长时间，超过后断开连接，单位毫秒，仅Linux)
+	TcpUserTimeout int
+}
+
+// newTcpConfig creates the tcp socket options from the Tcp* fields of the config
+// (根据配置中的Tcp*字段创建tcp socket选项)
+func newTcpConfig(config *gconf.Config) *TcpConfig {
+	return &TcpConfig{
+		TcpNoDelay:     config.TcpNoDelay,
+		TcpKeepAlive:   config.TcpKeepAlive,
+		TcpReadBuffer:  config.TcpReadBuffer,
+		TcpWriteBuffer: config.TcpWriteBuffer,
+		TcpLinger:      config.TcpLinger,
+		TcpUserTimeout: config.TcpUserTimeout,
+	}
+}
+
+// apply sets the socket options of a connection, connections not backed by a tcp socket are left untouched
+// (为连接设置socket选项，底层不是tcp socket的连接不做处理)
+func (t *TcpConfig) apply(conn net.Conn) error {
+	tc, ok := tcpConnOf(conn)
+	if !ok {
+		return nil
+	}
+
+	if t.TcpNoDelay != 0 {
+		if err := tc.SetNoDelay(t.TcpNoDelay > 0); err != nil {
+			return err
+		}
+	}
+	if t.TcpKeepAlive > 0 {
+		if err := tc.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tc.SetKeepAlivePeriod(time.Duration(t.TcpKeepAlive) * time.Second); err != nil {
+			return err
+		}
+	} else if t.TcpKeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if t.TcpReadBuffer > 0 {
+		if err := tc.SetReadBuffer(t.TcpReadBuffer); err != nil {
+			return err
+		}
+	}
+	if t.TcpWriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(t.TcpWriteBuffer); err != nil {
+			return err
+		}
+	}
+	if t.TcpLinger > 0 {
+		if err := tc.SetLinger(t.TcpLinger); err != nil {
+			return err
+		}
+	} else if t.TcpLinger < 0 {
+		if err := tc.SetLinger(0); err != nil {
+			return err
+		}
+	}
+	if t.TcpUserTimeout > 0 {
+		if err := setTcpUserTimeout(tc, time.Duration(t.TcpUserTimeout)*time.Millisecond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tcpConnOf unwraps the TLS and PROXY protocol layers of a connection down to its tcp socket
+// (剥离连接的TLS和PROXY protocol层，得到底层的tcp socket)
+func tcpConnOf(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *proxyProtoConn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package gnet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/liyee/gray/gconf"
+)
+
+// The maximum time allowed for a TLS handshake before the connection starts
+// (连接启动前TLS握手允许的最长时间)
+const tlsHandshakeTimeout = 10 * time.Second
+
+// useTLS reports whether the server certificate and private key are configured
+// (是否配置了服务端证书和私钥)
+func (s *Server) useTLS() bool {
+	return s.config.CertFile != "" && s.config.PrivateKeyFile != ""
+}
+
+// newTLSConfig builds the TLS config shared by the TCP and websocket listeners
+// (构建TCP和websocket监听共用的TLS配置)
+func (s *Server) newTLSConfig() (*tls.Config, error) {
+	// Read certificate and private key through the reloadable certificate source shared by all listeners
+	// (通过所有监听共用的可热加载证书源读取证书和私钥)
+	reloader, err := s.getCertReloader()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	tlsConfig.GetCertificate = reloader.GetCertificate
+	tlsConfig.Time = time.Now
+	tlsConfig.Rand = rand.Reader
+
+	// Mutual TLS, verify the client certificates with the configured CA bundle
+	// (双向TLS，使用配置的CA证书包校验客户端证书)
+	tlsConfig.ClientAuth, err = parseClientAuth(s.config.ClientAuth, s.config.ClientCAFile != "")
+	if err != nil {
+		return nil, err
+	}
+	if s.config.ClientCAFile != "" {
+		tlsConfig.ClientCAs, err = loadCertPool(s.config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// getCertReloader creates the certificate source on first use (首次使用时创建证书源)
+func (s *Server) getCertReloader() (*CertReloader, error) {
+	s.certOnce.Do(func() {
+		s.certReloader, s.certErr = NewCertReloader(s.config.CertFile, s.config.PrivateKeyFile)
+	})
+
+	return s.certReloader, s.certErr
+}
+
+// watchCertificate watches the certificate source until the server stops, it is started by init so that
+// the watcher never outlives the server (监听证书源的变化直到服务停止，由init启动，保证监听不会比服务存活更久)
+func (s *Server) watchCertificate() {
+	reloader, err := s.getCertReloader()
+	if err != nil {
+		// The listeners report the error when they build their TLS config (监听在构建TLS配置时会报告该错误)
+		return
+	}
+
+	var sigs []os.Signal
+	if s.config.CertReloadOnSIGHUP {
+		sigs = append(sigs, syscall.SIGHUP)
+	}
+	go reloader.Watch(s.exitChan, s.config.CertReloadIntervalDuration(), sigs...)
+}
+
+// ReloadCertificate re-reads the TLS certificate and private key, new handshakes use the new certificate
+// (重新读取TLS证书和私钥，新的握手将使用新证书)
+func (s *Server) ReloadCertificate() error {
+	reloader, err := s.getCertReloader()
+	if err != nil {
+		return err
+	}
+	return reloader.Reload()
+}
+
+// parseClientAuth converts the configured verify mode of client certificates
+// (转换客户端证书校验模式配置)
+func parseClientAuth(mode string, hasClientCA bool) (tls.ClientAuthType, error) {
+	switch mode {
+	case "":
+		if hasClientCA {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case gconf.ClientAuthNone:
+		return tls.NoClientCert, nil
+	case gconf.ClientAuthRequest:
+		return tls.RequestClientCert, nil
+	case gconf.ClientAuthRequire:
+		return tls.RequireAnyClientCert, nil
+	case gconf.ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	case gconf.ClientAuthRequireAndVerify:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode: %s", mode)
+	}
+}
+
+// newTLSConfig builds the client TLS config from the options set by WithWssClient and WithTLSCertClient
+// (根据WithWssClient和WithTLSCertClient设置的选项构建客户端TLS配置)
+func (c *Client) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: c.tlsServerName,
+	}
+
+	if c.tlsCAFile != "" {
+		pool, err := loadCertPool(c.tlsCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Client certificate presented to servers requiring mutual TLS (提供给双向TLS服务端的客户端证书)
+	if c.tlsCertFile != "" && c.tlsKeyFile != "" {
+		crt, err := tls.LoadX509KeyPair(c.tlsCertFile, c.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{crt}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCertPool reads a PEM encoded CA bundle
+// (读取PEM格式的CA证书包)
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + caFile)
+	}
+
+	return pool, nil
+}
+
+// tlsHandshake completes the TLS handshake of conn if it is a TLS connection,
+// so that the peer certificates are available in the OnConnStart hook
+// (如果conn是TLS连接则完成握手，使OnConnStart钩子中可以获取对端证书)
+func tlsHandshake(ctx context.Context, conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+
+	return tlsConn.HandshakeContext(ctx)
+}
+
+// tlsConnectionState returns the TLS connection state of conn, ok is false if it is not a TLS connection
+// (获取conn的TLS连接状态，不是TLS连接时ok为false)
+func tlsConnectionState(conn net.Conn) (tls.ConnectionState, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+
+	return tlsConn.ConnectionState(), true
+}
+
+// verifiedPeerCertificates returns the verified certificate chain of the peer, from leaf to root
+// (获取对端经过校验的证书链，从叶子证书到根证书)
+func verifiedPeerCertificates(conn net.Conn) []*x509.Certificate {
+	state, ok := tlsConnectionState(conn)
+	if !ok || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0]
+}
@@ -2,11 +2,14 @@ package gnet
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/liyee/gray/gconf"
@@ -42,6 +45,9 @@ type WsConnection struct {
 	// (消息管理MsgID和对应处理方法的消息管理模块)
 	msgHandler giface.IMsgHandler
 
+	// The config inherited from the Server or Client (从Server或Client继承的配置)
+	config *gconf.Config
+
 	// ctx and cancel are used to notify that the connection has exited/stopped.
 	// (告知该链接已经退出/停止的channel)
 	ctx    context.Context
@@ -51,6 +57,10 @@ type WsConnection struct {
 	// (有缓冲管道，用于读、写两个goroutine之间的消息通信)
 	msgBuffChan chan []byte
 
+	// Number of buffered messages that have not been written yet
+	// (发送缓冲队列中尚未写出的消息数量)
+	pendingBuffMsg int64
+
 	// msgLock is used for locking when users send and receive messages.
 	// (用户收发消息的Lock)
 	msgLock sync.RWMutex
@@ -61,8 +71,8 @@ type WsConnection struct {
 	// propertyLock protects the current property lock. (保护当前property的锁)
 	propertyLock sync.Mutex
 
-	// isClosed is the current connection's closed state. (当前连接的关闭状态)
-	isClosed bool
+	// closed is the current connection's closed state. (当前连接的关闭状态)
+	closed int32
 
 	// connManager is the Connection Manager to which the current connection belongs. (当前链接是属于哪个Connection Manager的)
 	connManager giface.IConnManager
@@ -79,9 +89,9 @@ type WsConnection struct {
 	// (数据报文封包方式)
 	packet giface.IDataPack
 
-	// lastActivityTime is the last time the connection was active.
-	// (最后一次活动时间)
-	lastActivityTime time.Time
+	// lastActivityTime is the last time the connection was active in UnixNano, accessed atomically.
+	// (最后一次活动时间(UnixNano)，使用原子操作访问)
+	lastActivityTime int64
 
 	// frameDecoder is the decoder for splitting or splicing data packets.
 	// (断粘包解码器)
@@ -105,6 +115,10 @@ type WsConnection struct {
 
 	// Close callback mutex
 	closeCallbackMutex sync.RWMutex
+
+	// Pending calls waiting for their replies
+	// (等待应答的调用)
+	calls callTable
 }
 
 // newServerConn: for Server, a method to create a connection with Server characteristics
@@ -117,13 +131,14 @@ func newWebsocketConn(server giface.IServer, conn *websocket.Conn, connID uint64
 		conn:        conn,
 		connID:      connID,
 		connIdStr:   strconv.FormatUint(connID, 10),
-		isClosed:    false,
 		msgBuffChan: nil,
 		property:    nil,
 		name:        server.ServerName(),
 		localAddr:   conn.LocalAddr().String(),
 		remoteAddr:  conn.RemoteAddr().String(),
 	}
+	// The context exists from the start so Stop works before Start (context在创建时即存在，使Start之前也可以Stop)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := server.GetLengthField()
 	if lengthField != nil {
@@ -132,9 +147,10 @@ func newWebsocketConn(server giface.IServer, conn *websocket.Conn, connID uint64
 
 	// Inherited attributes from server (从server继承过来的属性)
 	c.packet = server.GetPacket()
-	c.onConnStart = server.GetOnConnStart()
+	c.onConnStart = onConnStartOf(server)
 	c.onConnStop = server.GetOnConnStop()
 	c.msgHandler = server.GetMsgHandler()
+	c.config = configOf(server)
 
 	// Bind the current Connection to the Server's ConnManager (将当前的Connection与Server的ConnManager绑定)
 	c.connManager = server.GetConnMgr()
@@ -152,13 +168,13 @@ func newWsClientConn(client giface.IClient, conn *websocket.Conn) giface.IConnec
 		conn:        conn,
 		connID:      0,  // client ignore
 		connIdStr:   "", // client ignore
-		isClosed:    false,
 		msgBuffChan: nil,
 		property:    nil,
 		name:        client.GetName(),
 		localAddr:   conn.LocalAddr().String(),
 		remoteAddr:  conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := client.GetLengthField()
 	if lengthField != nil {
@@ -167,9 +183,10 @@ func newWsClientConn(client giface.IClient, conn *websocket.Conn) giface.IConnec
 
 	// Inherit properties from client (从client继承过来的属性)
 	c.packet = client.GetPacket()
-	c.onConnStart = client.GetOnConnStart()
+	c.onConnStart = onClientConnStartOf(client)
 	c.onConnStop = client.GetOnConnStop()
 	c.msgHandler = client.GetMsgHandler()
+	c.config = configOf(client)
 
 	return c
 }
@@ -184,7 +201,9 @@ func (c *WsConnection) StartWriter() {
 		select {
 		case data, ok := <-c.msgBuffChan:
 			if ok {
-				if err := c.Send(data); err != nil {
+				err := c.Send(data)
+				atomic.AddInt64(&c.pendingBuffMsg, -1)
+				if err != nil {
 					glog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
 					break
 				}
@@ -268,7 +287,12 @@ func (c *WsConnection) StartReader() {
 // Start starts the connection and makes it work.
 // (Start 启动连接，让当前连接开始工作)
 func (c *WsConnection) Start() {
-	c.ctx, c.cancel = context.WithCancel(context.Background())
+	// Stopped before it was started, e.g. by ClearConn while the server shuts down
+	// (启动之前已被停止，例如服务关闭时被ClearConn停止)
+	if c.ctx.Err() != nil {
+		c.abort()
+		return
+	}
 	// Execute the hook method according to the business needs of creating the connection passed in by the user.
 	// (按照用户传递进来的创建连接时需要处理的业务，执行钩子方法)
 	c.callOnConnStart()
@@ -303,6 +327,29 @@ func (c *WsConnection) Stop() {
 	c.cancel()
 }
 
+// abort closes a connection that is not going to be started (关闭不会再启动的连接)
+func (c *WsConnection) abort() {
+	c.msgLock.Lock()
+	atomic.StoreInt32(&c.closed, 1)
+	c.msgLock.Unlock()
+
+	_ = c.conn.Close()
+	if c.connManager != nil {
+		c.connManager.Remove(c)
+	}
+
+	// Close callbacks may have been added before Start, e.g. by joining a group (启动前可能已添加关闭回调，例如加入分组)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				glog.Ins().ErrorF("Conn abort panic: %v", err)
+			}
+		}()
+
+		c.InvokeCloseCallbacks()
+	}()
+}
+
 func (c *WsConnection) GetConnection() net.Conn {
 	return nil
 }
@@ -336,10 +383,20 @@ func (c *WsConnection) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
 }
 
+// GetPeerCertificates returns the verified peer certificate chain of a wss connection
+// (获取wss连接对端经过校验的证书链)
+func (c *WsConnection) GetPeerCertificates() []*x509.Certificate {
+	return verifiedPeerCertificates(c.conn.UnderlyingConn())
+}
+
+func (c *WsConnection) GetTLSConnectionState() (tls.ConnectionState, bool) {
+	return tlsConnectionState(c.conn.UnderlyingConn())
+}
+
 func (c *WsConnection) Send(data []byte) error {
 	c.msgLock.RLock()
 	defer c.msgLock.RUnlock()
-	if c.isClosed == true {
+	if c.isClosed() {
 		return errors.New("WsConnection closed when send msg")
 	}
 
@@ -353,11 +410,23 @@ func (c *WsConnection) Send(data []byte) error {
 }
 
 func (c *WsConnection) SendToQueue(data []byte) error {
+	return c.sendToQueue(data, true)
+}
+
+// trySendToQueue puts data into the send queue without waiting, it fails at once if the queue is full
+// (不等待地将data放入发送队列，队列已满时立即失败)
+func (c *WsConnection) trySendToQueue(data []byte) error {
+	return c.sendToQueue(data, false)
+}
+
+// sendToQueue puts data into the send queue, waiting for a free slot for a while if wait is set
+// (将data放入发送队列，设置wait时会等待一段时间直到有空位)
+func (c *WsConnection) sendToQueue(data []byte, wait bool) error {
 	c.msgLock.RLock()
 	defer c.msgLock.RUnlock()
 
 	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, gconf.GlobalObject.MaxMsgChanLen)
+		c.msgBuffChan = make(chan []byte, c.config.MaxMsgChanLen)
 		// Start a goroutine for writing data back to the client,
 		// which only reads data from MsgBuffChan and hasn't allocated memory or started the coroutine until SendBuffMsg is called
 		// (开启用于写回客户端数据流程的Goroutine
@@ -365,10 +434,7 @@ func (c *WsConnection) SendToQueue(data []byte) error {
 		go c.StartWriter()
 	}
 
-	idleTimeout := time.NewTimer(5 * time.Millisecond)
-	defer idleTimeout.Stop()
-
-	if c.isClosed == true {
+	if c.isClosed() {
 		return errors.New("WsConnection closed when send buff msg")
 	}
 
@@ -377,8 +443,27 @@ func (c *WsConnection) SendToQueue(data []byte) error {
 		return errors.New("Pack data is nil ")
 	}
 
+	// Count the message before queuing it, the writer may dequeue it before the send returns
+	// (入队前先计数，因为发送返回前写协程可能已经取出该消息)
+	atomic.AddInt64(&c.pendingBuffMsg, 1)
+	if !wait {
+		select {
+		case c.msgBuffChan <- data:
+			return nil
+		default:
+			atomic.AddInt64(&c.pendingBuffMsg, -1)
+			metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
+			return errSendQueueFull
+		}
+	}
+
+	idleTimeout := time.NewTimer(5 * time.Millisecond)
+	defer idleTimeout.Stop()
+
 	select {
 	case <-idleTimeout.C:
+		atomic.AddInt64(&c.pendingBuffMsg, -1)
+		metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
 		return errors.New("send buff msg timeout")
 	case c.msgBuffChan <- data:
 		return nil
@@ -390,7 +475,7 @@ func (c *WsConnection) SendToQueue(data []byte) error {
 func (c *WsConnection) SendMsg(msgID uint32, data []byte) error {
 	c.msgLock.RLock()
 	defer c.msgLock.RUnlock()
-	if c.isClosed == true {
+	if c.isClosed() {
 		return errors.New("WsConnection closed when send msg")
 	}
 
@@ -408,6 +493,7 @@ func (c *WsConnection) SendMsg(msgID uint32, data []byte) error {
 		glog.Ins().ErrorF("SendMsg err msg ID = %d, data = %+v, err = %+v", msgID, string(msg), err)
 		return err
 	}
+	metricsOf(c.msgHandler).msgSent(msgID, len(data))
 
 	return nil
 }
@@ -418,7 +504,7 @@ func (c *WsConnection) SendBuffMsg(msgID uint32, data []byte) error {
 	defer c.msgLock.RUnlock()
 
 	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, gconf.GlobalObject.MaxMsgChanLen)
+		c.msgBuffChan = make(chan []byte, c.config.MaxMsgChanLen)
 		// Start the Goroutine for writing back to the client data stream
 		// This method only reads data from MsgBuffChan, allocating memory and starting Goroutine without calling SendBuffMsg
 		// (开启用于写回客户端数据流程的Goroutine
@@ -429,7 +515,7 @@ func (c *WsConnection) SendBuffMsg(msgID uint32, data []byte) error {
 	idleTimeout := time.NewTimer(5 * time.Millisecond)
 	defer idleTimeout.Stop()
 
-	if c.isClosed == true {
+	if c.isClosed() {
 		return errors.New("WsConnection closed when send buff msg")
 	}
 
@@ -441,15 +527,31 @@ func (c *WsConnection) SendBuffMsg(msgID uint32, data []byte) error {
 		return errors.New("Pack error msg ")
 	}
 
+	// Count the message before queuing it, the writer may dequeue it before the send returns
+	// (入队前先计数，因为发送返回前写协程可能已经取出该消息)
+	atomic.AddInt64(&c.pendingBuffMsg, 1)
 	// Send timeout
 	select {
 	case <-idleTimeout.C:
+		atomic.AddInt64(&c.pendingBuffMsg, -1)
+		metricsOf(c.msgHandler).sendQueueTimeout(transportOf(c))
 		return errors.New("send buff msg timeout")
 	case c.msgBuffChan <- msg:
+		metricsOf(c.msgHandler).msgSent(msgID, len(data))
 		return nil
 	}
 }
 
+// Call sends data to msgID of the peer and waits for its reply, see giface.IConnection.Call
+// (向对端的msgID发送data并等待应答，见giface.IConnection.Call)
+func (c *WsConnection) Call(msgID uint32, data []byte, timeout time.Duration) (giface.IMessage, error) {
+	return c.calls.call(c, msgID, data, timeout)
+}
+
+func (c *WsConnection) resolveCall(corrID uint32, msg giface.IMessage) bool {
+	return c.calls.resolve(corrID, msg)
+}
+
 func (c *WsConnection) SetProperty(key string, value interface{}) {
 	c.propertyLock.Lock()
 	defer c.propertyLock.Unlock()
@@ -478,6 +580,33 @@ func (c *WsConnection) RemoveProperty(key string) {
 	delete(c.property, key)
 }
 
+// GetProperties returns a copy of all the connection properties (返回所有链接属性的副本)
+func (c *WsConnection) GetProperties() map[string]interface{} {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+
+	properties := make(map[string]interface{}, len(c.property))
+	for k, v := range c.property {
+		properties[k] = v
+	}
+	return properties
+}
+
+// LastActivity returns the last time data was received from the peer (返回最后一次收到对端数据的时间)
+func (c *WsConnection) LastActivity() time.Time {
+	nano := atomic.LoadInt64(&c.lastActivityTime)
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// SendQueueLen returns the number of buffered messages not written yet and the capacity of the send queue
+// (返回发送缓冲队列中尚未写出的消息数量及队列容量)
+func (c *WsConnection) SendQueueLen() (int, int) {
+	return int(atomic.LoadInt64(&c.pendingBuffMsg)), int(c.config.MaxMsgChanLen)
+}
+
 // Context returns the context for the connection, which can be used by user-defined goroutines to get the connection exit status.
 // (返回ctx，用于用户自定义的go程获取连接退出状态)
 func (c *WsConnection) Context() context.Context {
@@ -494,7 +623,7 @@ func (c *WsConnection) finalizer() {
 
 	// If the current connection is already closed.
 	// (如果当前链接已经关闭)
-	if c.isClosed == true {
+	if c.isClosed() {
 		return
 	}
 
@@ -521,7 +650,7 @@ func (c *WsConnection) finalizer() {
 	}
 
 	// Set the flag to indicate that the connection is closed. (设置标志位)
-	c.isClosed = true
+	atomic.StoreInt32(&c.closed, 1)
 
 	go func() {
 		defer func() {
@@ -551,17 +680,17 @@ func (c *WsConnection) callOnConnStop() {
 }
 
 func (c *WsConnection) IsAlive() bool {
-	if c.isClosed {
+	if c.isClosed() {
 		return false
 	}
 	// Check the time duration since the last activity of the connection, if it exceeds the maximum heartbeat interval,
 	// then the connection is considered dead
 	// (检查连接最后一次活动时间，如果超过心跳间隔，则认为连接已经死亡)
-	return time.Now().Sub(c.lastActivityTime) < gconf.GlobalObject.HeartbeatMaxDuration()
+	return time.Since(c.LastActivity()) < c.config.HeartbeatMaxDuration()
 }
 
 func (c *WsConnection) updateActivity() {
-	c.lastActivityTime = time.Now()
+	atomic.StoreInt64(&c.lastActivityTime, time.Now().UnixNano())
 }
 
 func (c *WsConnection) SetHeartBeat(checker giface.IHeartbeatChecker) {
@@ -584,8 +713,21 @@ func (c *WsConnection) GetMsgHandler() giface.IMsgHandler {
 	return c.msgHandler
 }
 
+func (c *WsConnection) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+func (c *WsConnection) getPacket() giface.IDataPack {
+	return c.packet
+}
+
+// GetConfig returns the config inherited from the Server or Client (返回从Server或Client继承的配置)
+func (c *WsConnection) GetConfig() *gconf.Config {
+	return c.config
+}
+
 func (s *WsConnection) AddCloseCallback(handler, key interface{}, f func()) {
-	if s.isClosed {
+	if s.isClosed() {
 		return
 	}
 	s.closeCallbackMutex.Lock()
@@ -594,7 +736,7 @@ func (s *WsConnection) AddCloseCallback(handler, key interface{}, f func()) {
 }
 
 func (s *WsConnection) RemoveCloseCallback(handler, key interface{}) {
-	if s.isClosed {
+	if s.isClosed() {
 		return
 	}
 	s.closeCallbackMutex.Lock()
@@ -0,0 +1,35 @@
+package gpack
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The optional correlation ID of request/response calls. A call or a reply sets one of the two highest bits of
+// the msgID and prefixes the data with a big-endian uint32 correlation ID, so the frame format is unchanged and
+// peers that never make calls are not affected. Routed msgIDs must therefore not exceed MsgIDMask.
+// (请求/响应调用的可选关联ID。调用或应答会置位msgID的最高两位之一，并在数据前加上大端uint32的关联ID，
+// 因此帧格式不变，不使用调用的对端不受影响。路由的msgID因此不能超过MsgIDMask)
+const (
+	MsgIDCallFlag    uint32 = 1 << 31            // the message is a call expecting a reply (消息是一个等待应答的调用)
+	MsgIDReplyFlag   uint32 = 1 << 30            // the message is the reply of a call (消息是一个调用的应答)
+	MsgIDMask        uint32 = MsgIDReplyFlag - 1 // the bits of the routed msgID (路由msgID所占的位)
+	CorrelationIDLen        = 4                  // the length of the correlation ID prefix (关联ID前缀的长度)
+)
+
+// WithCorrelationID returns data prefixed with the correlation ID corrID (返回加上关联ID前缀的数据)
+func WithCorrelationID(corrID uint32, data []byte) []byte {
+	buf := make([]byte, CorrelationIDLen+len(data))
+	binary.BigEndian.PutUint32(buf, corrID)
+	copy(buf[CorrelationIDLen:], data)
+	return buf
+}
+
+// SplitCorrelationID splits the data of a call or reply into its correlation ID and payload
+// (将调用或应答的数据拆分为关联ID和实际数据)
+func SplitCorrelationID(data []byte) (corrID uint32, payload []byte, err error) {
+	if len(data) < CorrelationIDLen {
+		return 0, nil, errors.New("correlation id missing")
+	}
+	return binary.BigEndian.Uint32(data), data[CorrelationIDLen:], nil
+}
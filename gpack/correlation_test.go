@@ -0,0 +1,62 @@
+package gpack
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCorrelationID(t *testing.T) {
+	tests := []struct {
+		name   string
+		corrID uint32
+		data   []byte
+		want   []byte
+	}{
+		{name: "empty payload", corrID: 1, data: nil, want: []byte{0, 0, 0, 1}},
+		{name: "payload", corrID: 0x01020304, data: []byte("hi"), want: []byte{1, 2, 3, 4, 'h', 'i'}},
+		{name: "max id", corrID: 0xFFFFFFFF, data: []byte{0}, want: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithCorrelationID(tt.corrID, tt.data)
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("WithCorrelationID() = %v, want %v", got, tt.want)
+			}
+
+			corrID, payload, err := SplitCorrelationID(got)
+			if err != nil {
+				t.Fatalf("SplitCorrelationID() err = %v", err)
+			}
+			if corrID != tt.corrID || !bytes.Equal(payload, tt.data) {
+				t.Fatalf("SplitCorrelationID() = %d, %v, want %d, %v", corrID, payload, tt.corrID, tt.data)
+			}
+		})
+	}
+}
+
+func TestWithCorrelationIDCopiesData(t *testing.T) {
+	data := []byte("abc")
+	got := WithCorrelationID(7, data)
+	data[0] = 'x'
+	if got[CorrelationIDLen] != 'a' {
+		t.Fatal("WithCorrelationID() shares the data of the caller")
+	}
+}
+
+func TestSplitCorrelationIDShort(t *testing.T) {
+	for _, data := range [][]byte{nil, {}, {1}, {1, 2, 3}} {
+		if _, _, err := SplitCorrelationID(data); err == nil {
+			t.Errorf("SplitCorrelationID(%v) err = nil, want an error", data)
+		}
+	}
+}
+
+func TestMsgIDFlags(t *testing.T) {
+	if MsgIDCallFlag&MsgIDMask != 0 || MsgIDReplyFlag&MsgIDMask != 0 || MsgIDCallFlag&MsgIDReplyFlag != 0 {
+		t.Fatal("the call flags overlap each other or the routed msgID bits")
+	}
+	if MsgIDCallFlag|MsgIDReplyFlag|MsgIDMask != ^uint32(0) {
+		t.Fatal("the call flags and the routed msgID bits do not cover the msgID")
+	}
+}
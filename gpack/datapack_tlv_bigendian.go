@@ -11,13 +11,21 @@ import (
 
 var defaultHeaderLen uint32 = 8
 
-type DataPack struct{}
+type DataPack struct {
+	config *gconf.Config // the config limiting the packet size, nil means gconf.GlobalObject(限制包长度的配置)
+}
 
 // (封包拆包实例初始化方法)
 func NewDataPack() giface.IDataPack {
 	return &DataPack{}
 }
 
+// NewDataPackWithConfig creates a packer whose MaxPacketSize is taken from config
+// (创建一个从config中读取MaxPacketSize的封包拆包实例)
+func NewDataPackWithConfig(config *gconf.Config) giface.IDataPack {
+	return &DataPack{config: config}
+}
+
 // (获取包头长度方法)
 func (dp *DataPack) GetHeadLen() uint32 {
 	return defaultHeaderLen
@@ -69,7 +77,7 @@ func (dp *DataPack) Unpack(binaryData []byte) (giface.IMessage, error) {
 
 	// Check whether the data length exceeds the maximum allowed packet size
 	// (判断dataLen的长度是否超出我们允许的最大包长度)
-	if gconf.GlobalObject.MaxPacketSize > 0 && msg.GetDataLen() > gconf.GlobalObject.MaxPacketSize {
+	if maxPacketSize := maxPacketSizeOf(dp.config); maxPacketSize > 0 && msg.GetDataLen() > maxPacketSize {
 		return nil, errors.New("too large msg data received")
 	}
 
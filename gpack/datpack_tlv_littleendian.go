@@ -9,7 +9,9 @@ import (
 	"github.com/liyee/gray/giface"
 )
 
-type DataPackLtv struct{}
+type DataPackLtv struct {
+	config *gconf.Config // the config limiting the packet size, nil means gconf.GlobalObject(限制包长度的配置)
+}
 
 // NewDataPackLtv initializes a packing and unpacking instance
 // (封包拆包实例初始化方法)
@@ -17,6 +19,12 @@ func NewDataPackLtv() giface.IDataPack {
 	return &DataPackLtv{}
 }
 
+// NewDataPackLtvWithConfig creates a packer whose MaxPacketSize is taken from config
+// (创建一个从config中读取MaxPacketSize的封包拆包实例)
+func NewDataPackLtvWithConfig(config *gconf.Config) giface.IDataPack {
+	return &DataPackLtv{config: config}
+}
+
 // GetHeadLen returns the length of the message header
 // (获取包头长度方法)
 func (dp *DataPackLtv) GetHeadLen() uint32 {
@@ -71,7 +79,7 @@ func (dp *DataPackLtv) Unpack(binaryData []byte) (giface.IMessage, error) {
 
 	// Check whether the data length exceeds the maximum allowed packet size
 	// (判断dataLen的长度是否超出我们允许的最大包长度)
-	if gconf.GlobalObject.MaxPacketSize > 0 && msg.GetDataLen() > gconf.GlobalObject.MaxPacketSize {
+	if maxPacketSize := maxPacketSizeOf(dp.config); maxPacketSize > 0 && msg.GetDataLen() > maxPacketSize {
 		return nil, errors.New("too large msg data received")
 	}
 
@@ -3,6 +3,7 @@ package gpack
 import (
 	"sync"
 
+	"github.com/liyee/gray/gconf"
 	"github.com/liyee/gray/giface"
 )
 
@@ -21,20 +22,35 @@ func Factory() *pack_factory {
 }
 
 func (f *pack_factory) NewPack(kind string) giface.IDataPack {
+	return f.NewPackWithConfig(kind, nil)
+}
+
+// NewPackWithConfig creates a packer limited by the MaxPacketSize of config, nil means gconf.GlobalObject
+// (创建一个受config中MaxPacketSize限制的封包拆包实例，config为nil时使用gconf.GlobalObject)
+func (f *pack_factory) NewPackWithConfig(kind string, config *gconf.Config) giface.IDataPack {
 	var dataPack giface.IDataPack
 
 	switch kind {
 	// Zinx standard default packaging and unpackaging method
 	// (Zinx 标准默认封包拆包方式)
 	case giface.GrayDataPack:
-		dataPack = NewDataPack()
+		dataPack = NewDataPackWithConfig(config)
 	case giface.GrayDataPackOld:
-		dataPack = NewDataPackLtv()
+		dataPack = NewDataPackLtvWithConfig(config)
 		// case for custom packaging and unpackaging methods
 		// (case 自定义封包拆包方式case)
 	default:
-		dataPack = NewDataPack()
+		dataPack = NewDataPackWithConfig(config)
 	}
 
 	return dataPack
 }
+
+// maxPacketSizeOf returns the MaxPacketSize of config, or of gconf.GlobalObject if config is nil
+// (返回config中的MaxPacketSize，config为nil时使用gconf.GlobalObject)
+func maxPacketSizeOf(config *gconf.Config) uint32 {
+	if config == nil {
+		config = gconf.GlobalObject
+	}
+	return config.MaxPacketSize
+}